@@ -0,0 +1,8 @@
+// Package all registers every KYC check. Adding a check means adding its
+// package here; main doesn't need to change.
+package all
+
+import (
+	_ "github.com/data-preservation-programs/ground-control-kyc-lambda/checks/geoip"
+	_ "github.com/data-preservation-programs/ground-control-kyc-lambda/checks/minpower"
+)
@@ -0,0 +1,40 @@
+package chaintest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/lotus/chain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	client, err := chain.Dial(ctx, chain.Config{Endpoint: NewServer(t, Fixtures)})
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer client.Close()
+
+	ts, err := client.ChainHead(ctx)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2055000, ts.Height())
+
+	miner, err := address.NewFromString("f02620")
+	assert.Nil(t, err)
+
+	power, err := client.StateMinerPower(ctx, miner, types.EmptyTSK)
+	assert.Nil(t, err)
+	assert.Equal(t, "1731405574635520", power.MinerPower.QualityAdjPower.String())
+
+	info, err := client.StateMinerInfo(ctx, miner, types.EmptyTSK)
+	assert.Nil(t, err)
+	assert.Len(t, info.Multiaddrs, 1)
+
+	unknown, err := address.NewFromString("f09999999")
+	assert.Nil(t, err)
+	_, err = client.StateMinerPower(ctx, unknown, types.EmptyTSK)
+	assert.NotNil(t, err)
+}
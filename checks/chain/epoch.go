@@ -0,0 +1,101 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-state-types/abi"
+)
+
+// Network describes when a Filecoin network started and how fast it
+// produces epochs.
+type Network struct {
+	Name        string
+	GenesisTime time.Time
+	BlockDelay  time.Duration
+}
+
+var (
+	Mainnet = Network{
+		Name:        "mainnet",
+		GenesisTime: time.Unix(1598306400, 0).UTC(), // 2020-08-24T22:00:00Z
+		BlockDelay:  30 * time.Second,
+	}
+	Calibnet = Network{
+		Name:        "calibnet",
+		GenesisTime: time.Unix(1667326380, 0).UTC(), // 2022-11-01T18:13:00Z
+		BlockDelay:  30 * time.Second,
+	}
+)
+
+// NetworkByName looks a network up by name.
+func NetworkByName(name string) (Network, error) {
+	switch strings.ToLower(name) {
+	case "", "mainnet":
+		return Mainnet, nil
+	case "calibnet", "calibrationnet":
+		return Calibnet, nil
+	}
+	return Network{}, fmt.Errorf("unknown network %q", name)
+}
+
+// NetworkFromEnv reads FILECOIN_NETWORK, defaulting to mainnet.
+func NetworkFromEnv() (Network, error) {
+	return NetworkByName(os.Getenv("FILECOIN_NETWORK"))
+}
+
+// MaxDrift is how far the chain head may be from the epoch derived from
+// wall time before CrossCheck complains.
+const MaxDrift abi.ChainEpoch = 5
+
+// Clock derives the current epoch from wall time. Epochs are deterministic
+// (null rounds still advance the height), so no chain call is needed.
+type Clock struct {
+	Network Network
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+func NewClock(network Network) *Clock {
+	return &Clock{Network: network, Now: time.Now}
+}
+
+// EpochAt returns the epoch in progress at t.
+func (c *Clock) EpochAt(t time.Time) abi.ChainEpoch {
+	if t.Before(c.Network.GenesisTime) {
+		return 0
+	}
+	return abi.ChainEpoch(t.Sub(c.Network.GenesisTime) / c.Network.BlockDelay)
+}
+
+// EpochTime returns the time epoch starts.
+func (c *Clock) EpochTime(epoch abi.ChainEpoch) time.Time {
+	return c.Network.GenesisTime.Add(time.Duration(epoch) * c.Network.BlockDelay)
+}
+
+func (c *Clock) CurrentEpoch() abi.ChainEpoch {
+	return c.EpochAt(c.Now())
+}
+
+// CrossCheck compares the derived epoch with the node's chain head,
+// returning the head height and how far the derived epoch is ahead of it.
+// A large drift means the node is out of sync or the network is
+// misconfigured, and is logged.
+func (c *Clock) CrossCheck(ctx context.Context, api API) (abi.ChainEpoch, abi.ChainEpoch, error) {
+	ts, err := api.ChainHead(ctx)
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting chain head: %w", err)
+	}
+
+	head := ts.Height()
+	drift := c.CurrentEpoch() - head
+	if drift > MaxDrift || drift < -MaxDrift {
+		log.Printf("Warning: %s clock epoch is %d epochs away from chain head %d\n",
+			c.Network.Name, drift, head)
+	}
+	return head, drift, nil
+}
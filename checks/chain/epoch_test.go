@@ -0,0 +1,39 @@
+package chain_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain/chaintest"
+	"github.com/filecoin-project/go-state-types/abi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	mainnet := chain.NewClock(chain.Mainnet)
+	assert.EqualValues(t, 0, mainnet.EpochAt(chain.Mainnet.GenesisTime))
+	assert.EqualValues(t, 0, mainnet.EpochAt(chain.Mainnet.GenesisTime.Add(-time.Hour)))
+	assert.EqualValues(t, 1, mainnet.EpochAt(chain.Mainnet.GenesisTime.Add(30*time.Second)))
+	assert.EqualValues(t, 2055000, mainnet.EpochAt(time.Date(2022, 8, 8, 11, 0, 15, 0, time.UTC)))
+	assert.Equal(t, time.Date(2022, 8, 8, 11, 0, 0, 0, time.UTC), mainnet.EpochTime(2055000))
+
+	calibnet := chain.NewClock(chain.Calibnet)
+	assert.EqualValues(t, 2880, calibnet.EpochAt(chain.Calibnet.GenesisTime.Add(24*time.Hour)))
+
+	_, err := chain.NetworkByName("calibrationnet")
+	assert.Nil(t, err)
+	_, err = chain.NetworkByName("butterflynet")
+	assert.NotNil(t, err)
+}
+
+func TestClockCrossCheck(t *testing.T) {
+	clock := chain.NewClock(chain.Mainnet)
+	clock.Now = func() time.Time { return clock.EpochTime(2055003) }
+
+	head, drift, err := clock.CrossCheck(context.Background(), &chaintest.Fake{Height: 2055000})
+	assert.Nil(t, err)
+	assert.Equal(t, abi.ChainEpoch(2055000), head)
+	assert.Equal(t, abi.ChainEpoch(3), drift)
+}
@@ -0,0 +1,185 @@
+// Package chain talks to the Filecoin chain through a Lotus (or Venus)
+// JSON-RPC gateway.
+package chain
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/filecoin-project/go-address"
+	jsonrpc "github.com/filecoin-project/go-jsonrpc"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/filecoin-project/lotus/chain/types"
+)
+
+// API is the subset of the Lotus full node API the checks use. *Client
+// implements it against a real node; tests can substitute a fake.
+type API interface {
+	ChainHead(ctx context.Context) (*types.TipSet, error)
+	StateMinerPower(ctx context.Context, miner address.Address, tsk types.TipSetKey) (*lotusapi.MinerPower, error)
+	StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error)
+}
+
+const DefaultEndpoint = "wss://api.chain.love/rpc/v0"
+
+// Config says which node to dial and how long to wait for it.
+type Config struct {
+	// Endpoint is the ws(s):// or http(s):// JSON-RPC URL of the node.
+	Endpoint string
+	// Token is sent as a bearer token when set.
+	Token string
+	// DialTimeout bounds connecting to the node.
+	DialTimeout time.Duration
+	// CallTimeout bounds each API call.
+	CallTimeout time.Duration
+}
+
+// ConfigFromEnv reads LOTUS_API_ENDPOINT, LOTUS_API_TOKEN,
+// LOTUS_DIAL_TIMEOUT and LOTUS_CALL_TIMEOUT, defaulting to the public
+// api.chain.love gateway.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{
+		Endpoint:    DefaultEndpoint,
+		Token:       os.Getenv("LOTUS_API_TOKEN"),
+		DialTimeout: 10 * time.Second,
+		CallTimeout: 30 * time.Second,
+	}
+	if endpoint := os.Getenv("LOTUS_API_ENDPOINT"); endpoint != "" {
+		cfg.Endpoint = endpoint
+	}
+
+	var err error
+	if s := os.Getenv("LOTUS_DIAL_TIMEOUT"); s != "" {
+		if cfg.DialTimeout, err = time.ParseDuration(s); err != nil {
+			return Config{}, fmt.Errorf("invalid LOTUS_DIAL_TIMEOUT: %w", err)
+		}
+	}
+	if s := os.Getenv("LOTUS_CALL_TIMEOUT"); s != "" {
+		if cfg.CallTimeout, err = time.ParseDuration(s); err != nil {
+			return Config{}, fmt.Errorf("invalid LOTUS_CALL_TIMEOUT: %w", err)
+		}
+	}
+	return cfg, nil
+}
+
+// Client is a long lived connection to a Lotus node. It is safe for
+// concurrent use and meant to be shared by every invocation in a Lambda
+// container.
+type Client struct {
+	api         lotusapi.FullNodeStruct
+	closer      jsonrpc.ClientCloser
+	callTimeout time.Duration
+}
+
+var _ API = (*Client)(nil)
+
+type dialResult struct {
+	closer jsonrpc.ClientCloser
+	err    error
+}
+
+// Dial connects to the node described by cfg. ctx and cfg.DialTimeout only
+// bound the dial: the connection itself lives until Close.
+func Dial(ctx context.Context, cfg Config) (*Client, error) {
+	headers := http.Header{}
+	if cfg.Token != "" {
+		headers.Set("Authorization", "Bearer "+cfg.Token)
+	}
+
+	if cfg.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.DialTimeout)
+		defer cancel()
+	}
+
+	// go-jsonrpc ties the websocket to the context it is given and doesn't
+	// honour it while dialing, so dial in the background and give up on
+	// our own deadline.
+	c := &Client{callTimeout: cfg.CallTimeout}
+	dialed := make(chan dialResult, 1)
+	go func() {
+		closer, err := jsonrpc.NewMergeClient(context.Background(), cfg.Endpoint, "Filecoin",
+			[]interface{}{&c.api.Internal, &c.api.CommonStruct.Internal}, headers)
+		dialed <- dialResult{closer, err}
+	}()
+
+	select {
+	case r := <-dialed:
+		if r.err != nil {
+			return nil, fmt.Errorf("connecting with lotus at %s failed: %w", cfg.Endpoint, r.err)
+		}
+		c.closer = r.closer
+		return c, nil
+	case <-ctx.Done():
+		go func() {
+			if r := <-dialed; r.err == nil {
+				r.closer()
+			}
+		}()
+		return nil, fmt.Errorf("connecting with lotus at %s failed: %w", cfg.Endpoint, ctx.Err())
+	}
+}
+
+// Close shuts the connection down.
+func (c *Client) Close() {
+	c.closer()
+}
+
+func (c *Client) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.callTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, c.callTimeout)
+}
+
+func (c *Client) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.api.ChainHead(ctx)
+}
+
+func (c *Client) StateMinerPower(ctx context.Context, miner address.Address, tsk types.TipSetKey) (*lotusapi.MinerPower, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.api.StateMinerPower(ctx, miner, tsk)
+}
+
+func (c *Client) StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error) {
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	return c.api.StateMinerInfo(ctx, miner, tsk)
+}
+
+// IsActorNotFound reports whether err is the node saying there's no actor
+// at an address, e.g. for a miner ID that doesn't exist. Only the message
+// survives JSON-RPC, so that's what is matched.
+func IsActorNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "actor not found")
+}
+
+// Unavailable is an API whose every call fails with err. It stands in for
+// a node that couldn't be dialed, so checks that don't need the chain can
+// still run.
+func Unavailable(err error) API {
+	return unavailable{err}
+}
+
+type unavailable struct {
+	err error
+}
+
+func (u unavailable) ChainHead(ctx context.Context) (*types.TipSet, error) {
+	return nil, u.err
+}
+
+func (u unavailable) StateMinerPower(ctx context.Context, miner address.Address, tsk types.TipSetKey) (*lotusapi.MinerPower, error) {
+	return nil, u.err
+}
+
+func (u unavailable) StateMinerInfo(ctx context.Context, miner address.Address, tsk types.TipSetKey) (lotusapi.MinerInfo, error) {
+	return lotusapi.MinerInfo{}, u.err
+}
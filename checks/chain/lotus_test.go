@@ -0,0 +1,51 @@
+package chain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("LOTUS_API_ENDPOINT", "")
+	t.Setenv("LOTUS_API_TOKEN", "")
+	t.Setenv("LOTUS_DIAL_TIMEOUT", "")
+	t.Setenv("LOTUS_CALL_TIMEOUT", "")
+	cfg, err := ConfigFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultEndpoint, cfg.Endpoint)
+
+	t.Setenv("LOTUS_API_ENDPOINT", "https://lotus.example.com/rpc/v1")
+	t.Setenv("LOTUS_API_TOKEN", "secret")
+	t.Setenv("LOTUS_DIAL_TIMEOUT", "2s")
+	t.Setenv("LOTUS_CALL_TIMEOUT", "5s")
+	cfg, err = ConfigFromEnv()
+	assert.Nil(t, err)
+	assert.Equal(t, Config{
+		Endpoint:    "https://lotus.example.com/rpc/v1",
+		Token:       "secret",
+		DialTimeout: 2 * time.Second,
+		CallTimeout: 5 * time.Second,
+	}, cfg)
+
+	t.Setenv("LOTUS_CALL_TIMEOUT", "soon")
+	_, err = ConfigFromEnv()
+	assert.NotNil(t, err)
+}
+
+func TestDialUnreachable(t *testing.T) {
+	_, err := Dial(context.Background(), Config{
+		Endpoint:    "ws://127.0.0.1:1/rpc/v0",
+		DialTimeout: time.Second,
+	})
+	assert.NotNil(t, err)
+}
+
+func TestIsActorNotFound(t *testing.T) {
+	assert.True(t, IsActorNotFound(errors.New("resolution lookup failed (f01234): resolve address f01234: actor not found")))
+	assert.False(t, IsActorNotFound(errors.New("websocket connection closed")))
+	assert.False(t, IsActorNotFound(nil))
+}
@@ -1,6 +1,15 @@
 package checks
 
-import "context"
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/filecoin-project/go-address"
+)
 
 // "responseId": "ACYDBNg8yyGgwk051fZNDE5qZHAzZ_5YEfXpKl3XXZunSjsFZN8h2tSQghrDj2w-PK-QbB0",
 // "timestamp": "2022-07-25T08:40:17.905455Z",
@@ -11,14 +20,70 @@ import "context"
 // "1_minerid": "f0478563",
 // "1_city": "hangzhou",
 // "1_country": "CN"
+//
+// Operators running several miner actors list them all in sp_ids; minerid
+// is still accepted for a single miner.
 type FormSubmission struct {
-	Name    string `json:"your_name"`
-	SPName  string `json:"storage_provider_operator_name"`
-	Slack   string `json:"your_handle_on_filecoin_io_slack"`
-	Email   string `json:"your_email"`
-	MinerID string `json:"minerid"`
-	City    string `json:"city"`
-	Country string `json:"country"`
+	Name     string   `json:"your_name"`
+	SPName   string   `json:"storage_provider_operator_name"`
+	Slack    string   `json:"your_handle_on_filecoin_io_slack"`
+	Email    string   `json:"your_email"`
+	MinerID  string   `json:"minerid"`
+	MinerIDs []string `json:"sp_ids,omitempty"`
+	City     string   `json:"city"`
+	Country  string   `json:"country"`
+}
+
+// Miners returns every miner ID in the submission, minerid first, without
+// duplicates.
+func (f FormSubmission) Miners() []string {
+	var miners []string
+	seen := make(map[string]bool)
+	for _, m := range append([]string{f.MinerID}, f.MinerIDs...) {
+		m = strings.TrimSpace(m)
+		if m != "" && !seen[m] {
+			seen[m] = true
+			miners = append(miners, m)
+		}
+	}
+	return miners
+}
+
+// ForMiner returns a copy of the submission about minerID alone, which is
+// what each check sees.
+func (f FormSubmission) ForMiner(minerID string) FormSubmission {
+	f.MinerID = minerID
+	f.MinerIDs = nil
+	return f
+}
+
+// Validate rejects submissions the checks can't run against.
+func (f FormSubmission) Validate(maxMiners int) error {
+	miners := f.Miners()
+	if len(miners) == 0 {
+		return Errorf(KindInvalidInput, "no miner ID submitted")
+	}
+	if maxMiners > 0 && len(miners) > maxMiners {
+		return Errorf(KindInvalidInput, "%d miner IDs submitted, at most %d are allowed", len(miners), maxMiners)
+	}
+	for _, m := range miners {
+		if _, err := ParseMinerID(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseMinerID parses an ID address such as f01000.
+func ParseMinerID(minerID string) (address.Address, error) {
+	addr, err := address.NewFromString(minerID)
+	if err != nil {
+		return address.Undef, Errorf(KindInvalidInput, "invalid miner ID %q: %w", minerID, err)
+	}
+	if addr.Protocol() != address.ID {
+		return address.Undef, Errorf(KindInvalidInput, "miner ID %q is not an ID address", minerID)
+	}
+	return addr, nil
 }
 
 type NormalizedLocation struct {
@@ -36,24 +101,195 @@ type NormalizedMiner struct {
 	SPContactInfo string `json:"contact_info"`
 }
 
+// Merge copies every non-empty field of other into m, so each check only
+// needs to fill in the fields it knows about.
+func (m *NormalizedMiner) Merge(other NormalizedMiner) {
+	if other.SPID != 0 {
+		m.SPID = other.SPID
+	}
+	if other.LocCity != "" {
+		m.LocCity = other.LocCity
+	}
+	if other.LocCountry != "" {
+		m.LocCountry = other.LocCountry
+	}
+	if other.LocContinent != "" {
+		m.LocContinent = other.LocContinent
+	}
+	if other.Validated {
+		m.Validated = true
+	}
+	if other.SPContactInfo != "" {
+		m.SPContactInfo = other.SPContactInfo
+	}
+}
+
 type NormalizedOrg struct {
 	SPOrgID        string `json:"sp_org_id"`
 	SPOrganization string `json:"sp_organization"`
 	OrgContactInfo string `json:"org_contact_info"`
 }
 
+// NormalizedResponse describes one organization and each of the miners it
+// submitted, in submission order.
 type NormalizedResponse struct {
-	FormSubmission  FormSubmission
-	NormalizedMiner NormalizedMiner
-	NormalizedOrg   NormalizedOrg
+	FormSubmission   FormSubmission
+	NormalizedMiners []NormalizedMiner
+	NormalizedOrg    NormalizedOrg
+	Checks           []CheckResult
+	PolicyVersion    string
+	Error            *ErrorBody `json:",omitempty"`
+}
+
+// Status is the outcome of a single check.
+type Status string
+
+const (
+	StatusPass  Status = "pass"
+	StatusFail  Status = "fail"
+	StatusSkip  Status = "skip"
+	StatusError Status = "error"
+)
+
+// Evidence is the data a check based its verdict on. Each check only fills
+// in the fields that apply to it.
+type Evidence struct {
+	QualityAdjPower string   `json:"quality_adj_power,omitempty"`
+	MinPower        string   `json:"min_power,omitempty"`
+	MatchedIP       string   `json:"matched_ip,omitempty"`
+	MatchedProvider string   `json:"matched_provider,omitempty"`
+	CityMatch       string   `json:"city_match,omitempty"`
+	CitySimilarity  float64  `json:"city_similarity,omitempty"`
+	DistanceKm      *float64 `json:"distance_km,omitempty"`
+	ASN             string   `json:"asn,omitempty"`
+	ASName          string   `json:"as_name,omitempty"`
+	// FeedDates is when each data feed the check used was generated, by
+	// its name in the policy.
+	FeedDates map[string]time.Time `json:"feed_dates,omitempty"`
+	// StaleFeeds names the feeds older than the policy allows.
+	StaleFeeds []string `json:"stale_feeds,omitempty"`
+	// ExcludedIPs are the miner's IP addresses the check ignored.
+	ExcludedIPs []ExcludedIP `json:"excluded_ips,omitempty"`
+	// ProviderErrors are the lookups that failed and were skipped.
+	ProviderErrors []ProviderError `json:"provider_errors,omitempty"`
+}
+
+// ExcludedIP is an IP address a check ignored, and why.
+type ExcludedIP struct {
+	IP string `json:"ip"`
+	// Class is the kind of address, such as "private" or "reserved".
+	Class  string `json:"class"`
+	Reason string `json:"reason"`
+}
+
+// ProviderError is a lookup a check skipped because its provider failed.
+type ProviderError struct {
+	Provider string `json:"provider"`
+	// IP is the address being looked up, if the failure was for one.
+	IP    string `json:"ip,omitempty"`
+	Error string `json:"error"`
+}
+
+// CheckResult is the per-check, per-miner entry of the report returned to
+// Ground Control, on success and on failure.
+type CheckResult struct {
+	MinerID  string   `json:"miner_id"`
+	Check    string   `json:"check"`
+	Status   Status   `json:"status"`
+	Reason   string   `json:"reason"`
+	Evidence Evidence `json:"evidence"`
+}
+
+// Result is what a check returns to the pipeline: its verdict, and the
+// fields it contributes to the miner's record.
+type Result struct {
+	Status   Status
+	Reason   string
+	Evidence Evidence
+	Miner    NormalizedMiner
+}
+
+// State is shared by all the checks run against a single miner.
+type State struct {
+	// Miner accumulates what the checks run so far have contributed.
+	Miner NormalizedMiner
+	// Checks records the verdict of every check in the pipeline.
+	Checks []CheckResult
+	// Lotus is the chain client shared by every invocation of the
+	// container.
+	Lotus chain.API
+	// Clock derives the current epoch without a chain call.
+	Clock *chain.Clock
+	// Policy holds the thresholds the checks apply.
+	Policy *Policy
 }
 
+// Check is a single KYC rule. Checks register themselves from init() and
+// are run in order by Run, once per miner in the submission.
 type Check interface {
-	DoCheck(ctx context.Context, checkctx context.Context, args ...string) (NormalizedResponse, error)
+	// Name identifies the check in CHECK_ORDER and in error messages.
+	Name() string
+	// DoCheck returns the check's verdict on submission.MinerID. An error
+	// means the check couldn't reach a verdict at all.
+	DoCheck(ctx context.Context, submission FormSubmission, state *State) (Result, error)
 }
 
-var RegisteredChecks []Check
+// DefaultOrder is the order checks run in when CHECK_ORDER is not set.
+// Registered checks missing from the order run afterwards, sorted by name.
+var DefaultOrder = []string{"minpower", "geoip"}
+
+var RegisteredChecks = map[string]Check{}
 
 func Register(c Check) {
-	RegisteredChecks = append(RegisteredChecks, c)
+	name := c.Name()
+	if _, dup := RegisteredChecks[name]; dup {
+		panic(fmt.Sprintf("checks: Register called twice for %s", name))
+	}
+	RegisteredChecks[name] = c
+}
+
+// ParseOrder splits a comma separated list of check names, falling back to
+// DefaultOrder when it's empty.
+func ParseOrder(s string) []string {
+	var order []string
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			order = append(order, name)
+		}
+	}
+	if len(order) == 0 {
+		return DefaultOrder
+	}
+	return order
+}
+
+// Pipeline returns the registered checks in the given order, followed by any
+// registered checks the order doesn't mention.
+func Pipeline(order []string) ([]Check, error) {
+	pipeline := make([]Check, 0, len(RegisteredChecks))
+	seen := make(map[string]bool)
+	for _, name := range order {
+		c, ok := RegisteredChecks[name]
+		if !ok {
+			return nil, Errorf(KindInternal, "checks: unknown check %q", name)
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		pipeline = append(pipeline, c)
+	}
+
+	var rest []string
+	for name := range RegisteredChecks {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	for _, name := range rest {
+		pipeline = append(pipeline, RegisteredChecks[name])
+	}
+
+	return pipeline, nil
 }
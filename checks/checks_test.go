@@ -0,0 +1,224 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeCheck struct {
+	name   string
+	result Result
+	err    error
+	ran    *[]string
+}
+
+func (f *fakeCheck) Name() string {
+	return f.name
+}
+
+func (f *fakeCheck) DoCheck(ctx context.Context, submission FormSubmission, state *State) (Result, error) {
+	*f.ran = append(*f.ran, f.name)
+	if f.result.Status == "" {
+		f.result.Status = StatusPass
+	}
+	return f.result, f.err
+}
+
+func withRegistry(t *testing.T, cs ...Check) {
+	saved := RegisteredChecks
+	RegisteredChecks = map[string]Check{}
+	t.Cleanup(func() { RegisteredChecks = saved })
+	for _, c := range cs {
+		Register(c)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	var ran []string
+	withRegistry(t,
+		&fakeCheck{name: "c", ran: &ran},
+		&fakeCheck{name: "b", ran: &ran},
+		&fakeCheck{name: "a", ran: &ran},
+	)
+
+	pipeline, err := Pipeline(ParseOrder(" b, c "))
+	assert.Nil(t, err)
+	assert.Nil(t, Run(context.Background(), pipeline, FormSubmission{}, &State{}))
+	assert.Equal(t, []string{"b", "c", "a"}, ran)
+
+	_, err = Pipeline([]string{"missing"})
+	assert.NotNil(t, err)
+
+	assert.Panics(t, func() { Register(&fakeCheck{name: "a", ran: &ran}) })
+}
+
+func TestRunMergesContributions(t *testing.T) {
+	var ran []string
+	withRegistry(t,
+		&fakeCheck{name: "power", ran: &ran, result: Result{
+			Miner: NormalizedMiner{SPID: 1000},
+		}},
+		&fakeCheck{name: "geo", ran: &ran, result: Result{
+			Miner: NormalizedMiner{LocCity: "Warsaw", LocCountry: "PL"},
+		}},
+		&fakeCheck{name: "fail", ran: &ran, err: errors.New("nope")},
+		&fakeCheck{name: "never", ran: &ran},
+	)
+
+	pipeline, err := Pipeline([]string{"power", "geo", "fail", "never"})
+	assert.Nil(t, err)
+
+	state := &State{}
+	err = Run(context.Background(), pipeline, FormSubmission{}, state)
+	assert.EqualError(t, err, "fail: nope")
+	assert.Equal(t, []string{"power", "geo", "fail"}, ran)
+	assert.Equal(t, NormalizedMiner{SPID: 1000, LocCity: "Warsaw", LocCountry: "PL"}, state.Miner)
+}
+
+func TestRunRecordsVerdicts(t *testing.T) {
+	var ran []string
+	withRegistry(t,
+		&fakeCheck{name: "power", ran: &ran, result: Result{
+			Status:   StatusFail,
+			Reason:   "miner power too low",
+			Evidence: Evidence{QualityAdjPower: "1", MinPower: "2"},
+		}},
+		&fakeCheck{name: "geo", ran: &ran},
+	)
+
+	pipeline, err := Pipeline([]string{"power", "geo"})
+	assert.Nil(t, err)
+
+	state := &State{}
+	err = Run(context.Background(), pipeline, FormSubmission{}, state)
+	assert.EqualError(t, err, "power: miner power too low")
+	assert.Equal(t, []string{"power"}, ran)
+	assert.Equal(t, []CheckResult{
+		{
+			Check:    "power",
+			Status:   StatusFail,
+			Reason:   "miner power too low",
+			Evidence: Evidence{QualityAdjPower: "1", MinPower: "2"},
+		},
+		{
+			Check:  "geo",
+			Status: StatusSkip,
+			Reason: "not run: power: miner power too low",
+		},
+	}, state.Checks)
+}
+
+func TestErrorKinds(t *testing.T) {
+	cases := []struct {
+		err    error
+		kind   Kind
+		status int
+	}{
+		{Errorf(KindInvalidInput, "bad"), KindInvalidInput, 400},
+		{Errorf(KindCheckFailed, "no"), KindCheckFailed, 422},
+		{Errorf(KindUpstreamUnavailable, "down"), KindUpstreamUnavailable, 502},
+		{Errorf(KindInternal, "oops"), KindInternal, 500},
+		{errors.New("untyped"), KindInternal, 500},
+		{fmt.Errorf("geoip: %w", Errorf(KindUpstreamUnavailable, "down")), KindUpstreamUnavailable, 502},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.kind, KindOf(c.err))
+		assert.Equal(t, c.status, KindOf(c.err).HTTPStatus())
+		assert.Equal(t, c.err.Error(), NewErrorBody(c.err).Message)
+	}
+}
+
+func TestRunErrorKinds(t *testing.T) {
+	var ran []string
+	withRegistry(t,
+		&fakeCheck{name: "down", ran: &ran, err: Errorf(KindUpstreamUnavailable, "lotus is down")},
+		&fakeCheck{name: "fail", ran: &ran, result: Result{Status: StatusFail, Reason: "too far"}},
+	)
+
+	down, err := Pipeline([]string{"down"})
+	assert.Nil(t, err)
+	assert.Equal(t, KindUpstreamUnavailable, KindOf(Run(context.Background(), down, FormSubmission{}, &State{})))
+
+	fail, err := Pipeline([]string{"fail", "down"})
+	assert.Nil(t, err)
+	assert.Equal(t, KindCheckFailed, KindOf(Run(context.Background(), fail, FormSubmission{}, &State{})))
+}
+
+func FuzzValidate(f *testing.F) {
+	for _, seed := range []string{"", "f", "f0", "f01000", "t01000", "f0abc", "f099999999999999999999", "f1abc", "\x00"} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, minerID string) {
+		err := FormSubmission{MinerID: minerID}.Validate(0)
+		if err != nil {
+			assert.Equal(t, KindInvalidInput, KindOf(err))
+		}
+	})
+}
+
+func TestMiners(t *testing.T) {
+	f := FormSubmission{MinerID: "f01000", MinerIDs: []string{" f02620", "f01000", ""}}
+	assert.Equal(t, []string{"f01000", "f02620"}, f.Miners())
+	assert.Nil(t, f.Validate(2))
+	assert.Equal(t, KindInvalidInput, KindOf(f.Validate(1)))
+	assert.Equal(t, KindInvalidInput, KindOf(FormSubmission{}.Validate(0)))
+	assert.Equal(t, KindInvalidInput, KindOf(FormSubmission{MinerIDs: []string{"f01000", "f0x"}}.Validate(0)))
+
+	single := f.ForMiner("f02620")
+	assert.Equal(t, "f02620", single.MinerID)
+	assert.Equal(t, []string{"f02620"}, single.Miners())
+}
+
+type minerCheck struct {
+	results map[string]Result
+	errs    map[string]error
+}
+
+func (m *minerCheck) Name() string {
+	return "miner"
+}
+
+func (m *minerCheck) DoCheck(ctx context.Context, submission FormSubmission, state *State) (Result, error) {
+	return m.results[submission.MinerID], m.errs[submission.MinerID]
+}
+
+func TestRunMiners(t *testing.T) {
+	check := &minerCheck{
+		results: map[string]Result{
+			"f01000": {Status: StatusPass, Miner: NormalizedMiner{LocCountry: "PL"}},
+			"f02620": {Status: StatusFail, Reason: "too far"},
+		},
+		errs: map[string]error{
+			"f03000": Errorf(KindUpstreamUnavailable, "lotus is down"),
+		},
+	}
+	withRegistry(t, check)
+	pipeline, err := Pipeline(nil)
+	assert.Nil(t, err)
+
+	submission := FormSubmission{MinerIDs: []string{"f01000", "f02620", "f03000"}}
+	runs := RunMiners(context.Background(), pipeline, submission, State{})
+	if !assert.Len(t, runs, 3) {
+		return
+	}
+	assert.Equal(t, "f01000", runs[0].MinerID)
+	assert.Nil(t, runs[0].Err)
+	assert.Equal(t, "PL", runs[0].State.Miner.LocCountry)
+	assert.Equal(t, "f01000", runs[0].State.Checks[0].MinerID)
+	assert.Equal(t, KindCheckFailed, KindOf(runs[1].Err))
+	assert.Equal(t, KindUpstreamUnavailable, KindOf(runs[2].Err))
+
+	// Every miner must pass: the failed check decides, retrying won't help.
+	assert.Equal(t, KindCheckFailed, KindOf(AllMinersMustPass.Verdict(runs)))
+	assert.Equal(t, KindUpstreamUnavailable, KindOf(AllMinersMustPass.Verdict(runs[2:])))
+	assert.Nil(t, AllMinersMustPass.Verdict(runs[:1]))
+
+	// Any miner may pass: f01000 carries the submission.
+	assert.Nil(t, AnyMinerMayPass.Verdict(runs))
+	assert.Equal(t, KindUpstreamUnavailable, KindOf(AnyMinerMayPass.Verdict(runs[1:])))
+	assert.Equal(t, KindCheckFailed, KindOf(AnyMinerMayPass.Verdict(runs[1:2])))
+}
@@ -0,0 +1,78 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Kind classifies an error by whose fault it is, which decides the HTTP
+// status returned to the caller.
+type Kind string
+
+const (
+	// KindInvalidInput means the form submission itself is malformed.
+	KindInvalidInput Kind = "invalid_input"
+	// KindUpstreamUnavailable means Lotus, a data feed or a geocoding
+	// service could not be reached or returned garbage.
+	KindUpstreamUnavailable Kind = "upstream_unavailable"
+	// KindCheckFailed means the checks ran and the submission didn't pass.
+	KindCheckFailed Kind = "check_failed"
+	// KindInternal is anything else, including misconfiguration.
+	KindInternal Kind = "internal"
+)
+
+// HTTPStatus maps the kind onto the status code of the API response.
+func (k Kind) HTTPStatus() int {
+	switch k {
+	case KindInvalidInput:
+		return http.StatusBadRequest
+	case KindCheckFailed:
+		return http.StatusUnprocessableEntity
+	case KindUpstreamUnavailable:
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// Error is an error with a Kind attached.
+type Error struct {
+	Kind Kind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errorf formats an error like fmt.Errorf (including %w) and tags it with
+// kind.
+func Errorf(kind Kind, format string, a ...interface{}) error {
+	return &Error{Kind: kind, Err: fmt.Errorf(format, a...)}
+}
+
+// KindOf returns the kind of the first *Error in err's chain, or
+// KindInternal if there is none.
+func KindOf(err error) Kind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return KindInternal
+}
+
+// ErrorBody is the JSON error section of every non-200 response.
+type ErrorBody struct {
+	Kind    Kind   `json:"kind"`
+	Message string `json:"message"`
+}
+
+// NewErrorBody describes err for the API response.
+func NewErrorBody(err error) *ErrorBody {
+	return &ErrorBody{Kind: KindOf(err), Message: err.Error()}
+}
@@ -2,12 +2,17 @@ package geoip
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"time"
 )
 
 type IPsBaiduReport struct {
 	Date *string                   `json:"date"`
 	IPs  map[string]IPsBaiduRecord `json:"ipsBaidu"`
+	// Generated is Date parsed.
+	Generated time.Time `json:"-"`
 }
 
 type IPsBaiduRecord struct {
@@ -21,15 +26,41 @@ type IPsBaiduRecord struct {
 
 type BaiduDetail map[string]interface{}
 
-func LoadIPsBaidu(filepath string) (map[string]IPsBaiduRecord, error) {
+func LoadIPsBaidu(filepath string) (*IPsBaiduReport, error) {
 	if filepath == "" {
 		filepath = "testdata/ips-baidu-latest.json"
 	}
-	bytes, err := os.ReadFile(filepath)
+	f, err := os.Open(filepath)
 	if err != nil {
 		return nil, err
 	}
-	var report IPsBaiduReport
-	json.Unmarshal(bytes, &report)
-	return report.IPs, nil
+	defer f.Close()
+	return ReadIPsBaidu(f)
+}
+
+// ReadIPsBaidu parses a ips-baidu feed as it streams in. A feed that isn't
+// valid JSON, or lacks its date or records, is an error rather than an
+// empty report.
+func ReadIPsBaidu(r io.Reader) (*IPsBaiduReport, error) {
+	report := IPsBaiduReport{IPs: make(map[string]IPsBaiduRecord)}
+	date, found, err := decodeReport(r, "ips-baidu", "ipsBaidu", true, func(ip string, dec *json.Decoder) error {
+		var record IPsBaiduRecord
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		report.IPs[CanonicalIP(ip)] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("parsing ips-baidu feed: no ipsBaidu")
+	}
+	report.Date = date
+	report.Generated, err = parseFeedDate("ips-baidu", date)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
 }
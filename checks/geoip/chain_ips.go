@@ -0,0 +1,150 @@
+package geoip
+
+import (
+	"context"
+	"log"
+	"net/netip"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/filecoin-project/lotus/chain/types"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// maxDNSAddrDepth bounds how many /dnsaddr records are followed from one
+// multiaddr, since each may point at another.
+const maxDNSAddrDepth = 4
+
+// ChainIPs finds the IP addresses a miner announces on chain, so the geoip
+// check still has something to go on when the multiaddrs-ips feed hasn't
+// caught up with the miner, or is down.
+type ChainIPs struct {
+	API chain.API
+	// DNS resolves /dns, /dns4, /dns6 and /dnsaddr components. The system
+	// resolver is used when it's nil.
+	DNS *madns.Resolver
+}
+
+// MinerRecords returns a record for each IP address the miner's on-chain
+// multiaddrs resolve to, tagged Chain and seen at epoch, which started at
+// seen. Multiaddrs that can't be decoded or resolved are logged and
+// skipped.
+func (c ChainIPs) MinerRecords(ctx context.Context, minerID string, epoch int64, seen time.Time) ([]MultiaddrsIPsRecord, error) {
+	addr, err := checks.ParseMinerID(minerID)
+	if err != nil {
+		return nil, err
+	}
+	if c.API == nil {
+		return nil, checks.Errorf(checks.KindInternal, "no lotus client configured")
+	}
+	info, err := c.API.StateMinerInfo(ctx, addr, types.EmptyTSK)
+	if chain.IsActorNotFound(err) {
+		return nil, checks.Errorf(checks.KindInvalidInput, "no miner %s on chain: %w", minerID, err)
+	}
+	if err != nil {
+		return nil, checks.Errorf(checks.KindUpstreamUnavailable, "looking up miner info for %s: %w", minerID, err)
+	}
+
+	var peerID string
+	if info.PeerId != nil {
+		peerID = info.PeerId.String()
+	}
+
+	var records []MultiaddrsIPsRecord
+	for _, b := range info.Multiaddrs {
+		maddr, err := ma.NewMultiaddrBytes(b)
+		if err != nil {
+			log.Printf("Ignoring invalid multiaddr of %s on chain: %v\n", minerID, err)
+			continue
+		}
+		resolved, err := c.resolve(ctx, maddr)
+		if err != nil {
+			log.Printf("Unable to resolve %s of %s: %v\n", maddr, minerID, err)
+			continue
+		}
+		for _, r := range resolved {
+			netIP, err := manet.ToIP(r)
+			if err != nil {
+				log.Printf("No IP address in %s of %s\n", r, minerID)
+				continue
+			}
+			ip, _ := netip.AddrFromSlice(netIP)
+			ip = ip.Unmap()
+			records = append(records, MultiaddrsIPsRecord{
+				Miner:     minerID,
+				Maddr:     r.String(),
+				PeerID:    peerID,
+				IP:        ip.String(),
+				Epoch:     uint(epoch),
+				Timestamp: seen.UTC().Format(time.RFC3339),
+				Chain:     true,
+				Addr:      ip,
+			})
+		}
+	}
+	log.Printf("Found %d IP addresses of %s on chain\n", len(records), minerID)
+	return records, nil
+}
+
+// resolve replaces the DNS components of maddr with the addresses they
+// resolve to, following /dnsaddr records to other /dnsaddr records.
+func (c ChainIPs) resolve(ctx context.Context, maddr ma.Multiaddr) ([]ma.Multiaddr, error) {
+	if !madns.Matches(maddr) {
+		return []ma.Multiaddr{maddr}, nil
+	}
+	dns := c.DNS
+	if dns == nil {
+		dns = madns.DefaultResolver
+	}
+
+	pending := []ma.Multiaddr{maddr}
+	var resolved []ma.Multiaddr
+	for depth := 0; len(pending) > 0 && depth < maxDNSAddrDepth; depth++ {
+		var next []ma.Multiaddr
+		for _, p := range pending {
+			addrs, err := dns.Resolve(ctx, p)
+			if err != nil {
+				return nil, err
+			}
+			for _, a := range addrs {
+				if madns.Matches(a) {
+					next = append(next, a)
+				} else {
+					resolved = append(resolved, a)
+				}
+			}
+		}
+		pending = next
+	}
+	return resolved, nil
+}
+
+// chainOnlyGeoData is GeoData without any of the feeds, for when only the
+// miner's on-chain addresses can be used. The providers that don't rely on
+// the feeds can still locate them.
+func chainOnlyGeoData() (*GeoData, error) {
+	return newGeoData(&MultiaddrsIPsReport{}, &IPsGeolite2Report{}, &IPsBaiduReport{})
+}
+
+// withChainRecords returns a view of g whose miner and IP lookups also
+// return records, found on chain, that the feed doesn't hold. The feed
+// data is shared, not copied.
+func (g *GeoData) withChainRecords(records []MultiaddrsIPsRecord) *GeoData {
+	g.index()
+	view := &GeoData{
+		MultiaddrsIPs: g.MultiaddrsIPs,
+		Ipinfo:        g.Ipinfo,
+		IPsGeolite2:   g.IPsGeolite2,
+		IPsBaidu:      g.IPsBaidu,
+		IPsGeoIP2:     g.IPsGeoIP2,
+		Dates:         g.Dates,
+		byMiner:       g.byMiner,
+		byIP:          g.byIP,
+		chain:         append(append([]MultiaddrsIPsRecord{}, g.chain...), records...),
+	}
+	view.indexOnce.Do(func() {})
+	return view
+}
@@ -0,0 +1,183 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain/chaintest"
+	"github.com/filecoin-project/go-address"
+	"github.com/filecoin-project/go-state-types/abi"
+	lotusapi "github.com/filecoin-project/lotus/api"
+	ma "github.com/multiformats/go-multiaddr"
+	madns "github.com/multiformats/go-multiaddr-dns"
+	"github.com/stretchr/testify/assert"
+)
+
+func chainIPs(t *testing.T, miner string, maddrs ...string) ChainIPs {
+	addr, err := address.NewFromString(miner)
+	assert.Nil(t, err)
+	info := lotusapi.MinerInfo{}
+	for _, s := range maddrs {
+		if s == "invalid" {
+			info.Multiaddrs = append(info.Multiaddrs, abi.Multiaddrs{0xff, 0xff})
+			continue
+		}
+		info.Multiaddrs = append(info.Multiaddrs, ma.StringCast(s).Bytes())
+	}
+
+	dns, err := madns.NewResolver(madns.WithDefaultResolver(&madns.MockResolver{
+		IP: map[string][]net.IPAddr{
+			"sp.example.com": {{IP: net.ParseIP("91.209.232.11")}, {IP: net.ParseIP("2001:db8::11")}},
+			"v6.example.com": {{IP: net.ParseIP("91.209.232.12")}, {IP: net.ParseIP("2001:db8::12")}},
+		},
+		TXT: map[string][]string{
+			"_dnsaddr.example.org":        {"dnsaddr=/dnsaddr/nested.example.org"},
+			"_dnsaddr.nested.example.org": {"dnsaddr=/ip4/192.0.2.7/tcp/4001"},
+		},
+	}))
+	assert.Nil(t, err)
+
+	return ChainIPs{
+		API: &chaintest.Fake{Info: map[address.Address]lotusapi.MinerInfo{addr: info}},
+		DNS: dns,
+	}
+}
+
+func TestChainIPs(t *testing.T) {
+	seen := time.Date(2022, 8, 8, 11, 0, 0, 0, time.UTC)
+	c := chainIPs(t, "f02620",
+		"/ip4/91.209.232.10/tcp/24001",
+		"invalid",
+		"/dns4/sp.example.com/tcp/24001",
+		"/dns6/v6.example.com/tcp/24001",
+		"/dnsaddr/example.org",
+		"/dns4/missing.example.com/tcp/24001",
+	)
+
+	records, err := c.MinerRecords(context.Background(), "f02620", 2055000, seen)
+	assert.Nil(t, err)
+	var ips, maddrs []string
+	for _, r := range records {
+		ips = append(ips, r.IP)
+		maddrs = append(maddrs, r.Maddr)
+		assert.Equal(t, "f02620", r.Miner)
+		assert.True(t, r.Chain)
+		assert.EqualValues(t, 2055000, r.Epoch)
+		assert.Equal(t, "2022-08-08T11:00:00Z", r.Timestamp)
+	}
+	assert.Equal(t, []string{"91.209.232.10", "91.209.232.11", "2001:db8::12", "192.0.2.7"}, ips)
+	assert.Equal(t, "/ip4/91.209.232.11/tcp/24001", maddrs[1])
+
+	_, err = c.MinerRecords(context.Background(), "f01000", 2055000, seen)
+	assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err))
+	_, err = c.MinerRecords(context.Background(), "not a miner", 2055000, seen)
+	assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err))
+	_, err = ChainIPs{API: chain.Unavailable(errors.New("down"))}.MinerRecords(context.Background(), "f02620", 2055000, seen)
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+}
+
+func TestChainIPsMatch(t *testing.T) {
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	geo := policy.Geo
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "geolite2"}}
+
+	// A miner the feed doesn't know yet, announcing an address on chain
+	miner := MinerData{"f09999", "Warsaw", "PL"}
+	ok, _, err := GeoMatchExists(context.Background(), geodata, nil, geo, 2055000, miner)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	records, err := chainIPs(t, "f09999", "/ip4/91.209.232.10/tcp/24001").
+		MinerRecords(context.Background(), "f09999", 2055000, time.Now())
+	assert.Nil(t, err)
+	withChain := geodata.withChainRecords(records)
+	ok, data, err := GeoMatchExists(context.Background(), withChain, nil, geo, 2055000, miner)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "91.209.232.10", data.Match.IP)
+	assert.True(t, data.GeoData.MultiaddrsIPs[0].Chain)
+
+	// The view shares the feed data, which is left as it was
+	assert.Len(t, withChain.IPRecords("91.209.232.10"), len(geodata.IPRecords("91.209.232.10"))+1)
+	assert.Empty(t, geodata.MinerRecords("f09999"))
+}
+
+// The on-chain addresses are used alone when the feeds are down or stale.
+func TestDoCheckChainIPsOnly(t *testing.T) {
+	t.Setenv("GOOGLE_MAPS_API_KEY", "skip")
+	t.Setenv("MAXMIND_USER_ID", "skip")
+	t.Setenv("MAXMIND_DB_PATH", "")
+	t.Setenv("GAZETTEER_PATH", "")
+	t.Setenv("EPOCH", "")
+	newIPInfoServer(t)
+
+	srv := newFeedServer(t)
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	feeds, err := checks.ParsePolicy([]byte(fmt.Sprintf(`{
+		"version": "test",
+		"min_power": "0",
+		"geo": {"max_distance_km": 1, "ip_max_age_epochs": 1},
+		"feeds": {
+			"multiaddrs_ips": "%[1]s/multiaddrs-ips-latest.json",
+			"ips_geolite2": "%[1]s/ips-geolite2-latest.json",
+			"ips_baidu": "%[1]s/ips-baidu-latest.json",
+			"max_staleness": "72h", "on_stale": "reject"
+		}
+	}`, srv.URL)))
+	assert.Nil(t, err)
+	policy.Feeds = feeds.Feeds
+
+	clock := &chain.Clock{Network: chain.Mainnet}
+	now := clock.EpochTime(2055000)
+	clock.Now = func() time.Time { return now }
+
+	doCheck := func(lotus chain.API, minerID string) (checks.Result, error) {
+		return (&GeoIPCheck{}).DoCheck(context.Background(), checks.FormSubmission{
+			MinerID: minerID,
+			City:    "Warsaw",
+			Country: "PL",
+		}, &checks.State{Lotus: lotus, Clock: clock, Policy: policy})
+	}
+	onChain := chainIPs(t, "f09999", "/ip4/91.209.232.10/tcp/24001").API
+
+	// Feeds down
+	srv.mu.Lock()
+	srv.down = true
+	srv.mu.Unlock()
+	result, err := doCheck(onChain, "f09999")
+	assert.Nil(t, err)
+	assert.Equal(t, checks.StatusPass, result.Status)
+	assert.Equal(t, "ipinfo", result.Evidence.MatchedProvider)
+	assert.Empty(t, result.Evidence.FeedDates)
+
+	// With no addresses on chain either, the feeds are still needed
+	_, err = doCheck(&chaintest.Fake{}, "f02620")
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+
+	// Feeds up, but a week older than the policy allows
+	srv.mu.Lock()
+	srv.down = false
+	srv.mu.Unlock()
+	now = clock.EpochTime(2055000 + 7*24*60*2)
+	result, err = doCheck(onChain, "f09999")
+	assert.Nil(t, err)
+	assert.Equal(t, checks.StatusPass, result.Status)
+	assert.Equal(t, "ipinfo", result.Evidence.MatchedProvider)
+
+	_, err = doCheck(&chaintest.Fake{}, "f02620")
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+}
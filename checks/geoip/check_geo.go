@@ -2,112 +2,236 @@ package geoip
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
-	"net/http"
-	"net/url"
+	"net/netip"
 	"os"
-	"path"
-	"strconv"
-	"strings"
+	"sync"
+	"time"
 
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/geoip/coords"
 	"github.com/jftuga/geodist"
 	"github.com/savaki/geoip2"
 	"googlemaps.github.io/maps"
 )
 
-const MAX_DISTANCE = 600
-const downloadsDir = "downloads"
-
 type GeoData struct {
 	MultiaddrsIPs []MultiaddrsIPsRecord
 	Ipinfo        *IPInfoResolver
 	IPsGeolite2   map[string]IPsGeolite2Record
 	IPsBaidu      map[string]IPsBaiduRecord
 	IPsGeoIP2     map[string]geoip2.Response
+	// Dates is when provider.quest generated the feeds.
+	Dates FeedDates
+
+	// byMiner and byIP index MultiaddrsIPs, built on first use.
+	indexOnce sync.Once
+	byMiner   map[string][]int
+	byIP      map[string][]int
+	// chain holds records found on chain rather than in the feed.
+	chain []MultiaddrsIPsRecord
+}
+
+func (g *GeoData) index() {
+	g.indexOnce.Do(func() {
+		g.byMiner = make(map[string][]int)
+		g.byIP = make(map[string][]int)
+		for i, m := range g.MultiaddrsIPs {
+			g.byMiner[m.Miner] = append(g.byMiner[m.Miner], i)
+			g.byIP[m.IP] = append(g.byIP[m.IP], i)
+		}
+	})
+}
+
+func (g *GeoData) records(indexes []int) []MultiaddrsIPsRecord {
+	records := make([]MultiaddrsIPsRecord, len(indexes))
+	for i, j := range indexes {
+		records[i] = g.MultiaddrsIPs[j]
+	}
+	return records
+}
+
+// MinerRecords returns the multiaddrs-ips records of a miner in feed
+// order, followed by those found on chain.
+func (g *GeoData) MinerRecords(minerID string) []MultiaddrsIPsRecord {
+	g.index()
+	records := g.records(g.byMiner[minerID])
+	for _, r := range g.chain {
+		if r.Miner == minerID {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// IPRecords returns the multiaddrs-ips records of an IP address, from every
+// miner announcing it, in feed order followed by those found on chain.
+func (g *GeoData) IPRecords(ip string) []MultiaddrsIPsRecord {
+	g.index()
+	ip = CanonicalIP(ip)
+	records := g.records(g.byIP[ip])
+	for _, r := range g.chain {
+		if r.IP == ip {
+			records = append(records, r)
+		}
+	}
+	return records
+}
+
+// FeedDates is when each provider.quest feed was generated.
+type FeedDates struct {
+	MultiaddrsIPs time.Time
+	IPsGeolite2   time.Time
+	IPsBaidu      time.Time
 }
 
-func LoadGeoData() (*GeoData, error) {
-	results, err := getLocationData()
+// parseFeedDate parses the date a feed was generated, which every feed
+// must carry.
+func parseFeedDate(feed string, date *string) (time.Time, error) {
+	if date == nil || *date == "" {
+		return time.Time{}, fmt.Errorf("parsing %s feed: no date", feed)
+	}
+	t, err := time.Parse(time.RFC3339, *date)
 	if err != nil {
-		return nil, err
+		return time.Time{}, fmt.Errorf("parsing %s feed: date: %w", feed, err)
+	}
+	return t, nil
+}
+
+// byPolicyName keys the dates by the feeds' names in the policy.
+func (d FeedDates) byPolicyName() map[string]time.Time {
+	return map[string]time.Time{
+		"multiaddrs_ips": d.MultiaddrsIPs,
+		"ips_geolite2":   d.IPsGeolite2,
+		"ips_baidu":      d.IPsBaidu,
+	}
+}
+
+// Stale returns the policy names of the feeds generated more than maxAge
+// before now, in policy order.
+func (d FeedDates) Stale(now time.Time, maxAge time.Duration) []string {
+	var stale []string
+	for _, f := range []struct {
+		name string
+		date time.Time
+	}{
+		{"multiaddrs_ips", d.MultiaddrsIPs},
+		{"ips_geolite2", d.IPsGeolite2},
+		{"ips_baidu", d.IPsBaidu},
+	} {
+		if now.Sub(f.date) > maxAge {
+			stale = append(stale, f.name)
+		}
+	}
+	return stale
+}
+
+// LoadGeoDataFiles loads already downloaded multiaddrs-ips, ips-geolite2 and
+// ips-baidu feeds.
+func LoadGeoDataFiles(multiaddrsIPsPath, ipsGeolite2Path, ipsBaiduPath string) (*GeoData, error) {
+	var readers [3]io.Reader
+	for i, p := range []string{multiaddrsIPsPath, ipsGeolite2Path, ipsBaiduPath} {
+		f, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		readers[i] = f
 	}
+	return ReadGeoData(readers[0], readers[1], readers[2])
+}
 
-	multiaddrsIPs, err := LoadMultiAddrsIPs(results[0])
+// ReadGeoData parses the multiaddrs-ips, ips-geolite2 and ips-baidu feeds.
+func ReadGeoData(multiaddrsIPsFeed, ipsGeolite2Feed, ipsBaiduFeed io.Reader) (*GeoData, error) {
+	multiaddrsIPs, err := ReadMultiAddrsIPs(multiaddrsIPsFeed)
 	if err != nil {
 		return nil, err
 	}
 
-	ipinfo, err := NewIPInfoResolver()
+	ipsGeolite2, err := ReadIPsGeolite2(ipsGeolite2Feed)
 	if err != nil {
 		return nil, err
 	}
 
-	ipsGeolite2, err := LoadIPsGeolite2(results[1])
+	ipsBaidu, err := ReadIPsBaidu(ipsBaiduFeed)
 	if err != nil {
 		return nil, err
 	}
 
-	ipsBaidu, err := LoadIPsBaidu(results[2])
+	return newGeoData(multiaddrsIPs, ipsGeolite2, ipsBaidu)
+}
+
+// newGeoData indexes the parsed feeds.
+func newGeoData(multiaddrsIPs *MultiaddrsIPsReport, ipsGeolite2 *IPsGeolite2Report, ipsBaidu *IPsBaiduReport) (*GeoData, error) {
+	ipinfo, err := NewIPInfoResolver()
 	if err != nil {
 		return nil, err
 	}
 
-	return &GeoData{
-		multiaddrsIPs,
-		ipinfo,
-		ipsGeolite2,
-		ipsBaidu,
-		make(map[string]geoip2.Response),
-	}, nil
+	g := &GeoData{
+		MultiaddrsIPs: multiaddrsIPs.MultiaddrsIPs,
+		Ipinfo:        ipinfo,
+		IPsGeolite2:   ipsGeolite2.IPs,
+		IPsBaidu:      ipsBaidu.IPs,
+		IPsGeoIP2:     make(map[string]geoip2.Response),
+		Dates: FeedDates{
+			MultiaddrsIPs: multiaddrsIPs.Generated,
+			IPsGeolite2:   ipsGeolite2.Generated,
+			IPsBaidu:      ipsBaidu.Generated,
+		},
+	}
+	g.index()
+	return g, nil
 }
 
-func (g *GeoData) filterByMinerID(ctx context.Context, minerID string, currentEpoch int64) (*GeoData, error) {
-	minEpoch := currentEpoch - 14*24*60*2 // 2 weeks
+// filterByMinerID narrows g down to the miner's recently seen public IP
+// addresses. Private, reserved and other addresses say nothing about where
+// the miner is, whatever a feed makes of them, and are returned as
+// excluded.
+func (g *GeoData) filterByMinerID(ctx context.Context, minerID string, currentEpoch int64, maxAgeEpochs int64) (*GeoData, []checks.ExcludedIP, error) {
+	minEpoch := currentEpoch - maxAgeEpochs
 	multiaddrsIPs := []MultiaddrsIPsRecord{}
 	ipsGeoLite2 := make(map[string]IPsGeolite2Record)
 	ipsBaidu := make(map[string]IPsBaiduRecord)
 	ipsGeoIP2 := make(map[string]geoip2.Response)
+	var excluded []checks.ExcludedIP
+	seenExcluded := make(map[string]bool)
 
-	ipinfo, err := NewIPInfoResolver()
-	if err != nil {
-		return nil, err
-	}
-
-	for _, m := range g.MultiaddrsIPs {
-		if m.Miner == minerID {
-			if int64(m.Epoch) < minEpoch {
-				log.Printf("IP address %s rejected, too old: %d < %d\n",
-					m.IP, m.Epoch, minEpoch)
-			} else {
-				multiaddrsIPs = append(multiaddrsIPs, m)
-				if r, ok := g.IPsGeolite2[m.IP]; ok {
-					ipsGeoLite2[m.IP] = r
-				}
-				if r, ok := g.IPsBaidu[m.IP]; ok {
-					ipsBaidu[m.IP] = r
-				}
-				// TODO: commenting this out until getting a valid MAXMIND_LICENSE_KEY
-				// r, err := getGeoIP2(ctx, m.IP)
-				// if err != nil {
-				// 	return &GeoData{}, err
-				// }
-
-				// ipsGeoIP2[m.IP] = r
+	for _, m := range g.MinerRecords(minerID) {
+		if int64(m.Epoch) < minEpoch {
+			log.Printf("IP address %s rejected, too old: %d < %d\n",
+				m.IP, m.Epoch, minEpoch)
+			continue
+		}
+		m.Addr = m.addr()
+		if class, reason := ClassifyIP(m.Addr); class != IPPublic {
+			if !seenExcluded[m.IP] {
+				log.Printf("IP address %s rejected, %s: %s\n", m.IP, class, reason)
+				excluded = append(excluded, checks.ExcludedIP{IP: m.IP, Class: string(class), Reason: reason})
+				seenExcluded[m.IP] = true
 			}
+			continue
+		}
+		m.IP = m.Addr.String()
+		multiaddrsIPs = append(multiaddrsIPs, m)
+		if r, ok := g.IPsGeolite2[m.IP]; ok {
+			ipsGeoLite2[m.IP] = r
+		}
+		if r, ok := g.IPsBaidu[m.IP]; ok {
+			ipsBaidu[m.IP] = r
 		}
 	}
 
 	return &GeoData{
-		multiaddrsIPs,
-		ipinfo,
-		ipsGeoLite2,
-		ipsBaidu,
-		ipsGeoIP2,
-	}, nil
+		MultiaddrsIPs: multiaddrsIPs,
+		Ipinfo:        g.Ipinfo,
+		IPsGeolite2:   ipsGeoLite2,
+		IPsBaidu:      ipsBaidu,
+		IPsGeoIP2:     ipsGeoIP2,
+		Dates:         g.Dates,
+	}, excluded, nil
 }
 
 type FinalGeoData struct {
@@ -115,291 +239,241 @@ type FinalGeoData struct {
 	GeocodeLocations  []geodist.Coord
 	GeoDataAddresses  []Address
 	GoogleGeocodeData []maps.GeocodingResult
+	// IPLocations is where each provider that ran placed the miner's IPs.
+	IPLocations []IPLocation
+	// ProviderErrors are the providers that failed, and were skipped.
+	ProviderErrors []checks.ProviderError
+	// ExcludedIPs are the miner's IP addresses left out, and why.
+	ExcludedIPs []checks.ExcludedIP
+	Match       *GeoMatch
 }
 
-func findMatchGeoLite2(g *GeoData, miner MinerData, locations []geodist.Coord) bool {
-	var match_found bool = false
-	for ip, geolite2 := range g.IPsGeolite2 {
-		// Match country
-		if geolite2.Country != miner.CountryCode {
-			log.Printf("No Geolite2 country match for %s (%s != GeoLite2:%s), IP: %s\n",
-				miner.MinerID, miner.CountryCode, geolite2.Country, ip)
+// GeoMatch records the first IP address that matched the miner's location,
+// and how it matched.
+type GeoMatch struct {
+	IP       string `json:"ip"`
+	Provider string `json:"provider"`
+	// City is the provider's name for the miner's city, when the names
+	// matched.
+	City           string    `json:"city,omitempty"`
+	CityMatch      CityMatch `json:"city_match,omitempty"`
+	CitySimilarity float64   `json:"city_similarity,omitempty"`
+	DistanceKm     *float64  `json:"distance_km,omitempty"`
+	ASN            string    `json:"asn,omitempty"`
+	ASName         string    `json:"as_name,omitempty"`
+}
+
+func (m *GeoMatch) evidence() checks.Evidence {
+	return checks.Evidence{
+		MatchedIP:       m.IP,
+		MatchedProvider: m.Provider,
+		CityMatch:       string(m.CityMatch),
+		CitySimilarity:  m.CitySimilarity,
+		DistanceKm:      m.DistanceKm,
+		ASN:             m.ASN,
+		ASName:          m.ASName,
+	}
+}
+
+// matched completes how loc matched with the IP address and who placed it.
+func matched(loc *IPLocation, how GeoMatch) *GeoMatch {
+	how.IP = loc.IP.String()
+	how.Provider = loc.Source
+	how.ASN = loc.ASN
+	how.ASName = loc.ASName
+	return &how
+}
+
+// findMatch looks for an IP address of the miner that provider places near
+// the city it submitted: first by country, then by city name, then by
+// distance from the geocoded locations. City names match when they're at
+// least minSimilarity alike, see MatchCity. It stops at the first IP that
+// matches, and returns every location the provider knew about until then,
+// and the lookups that failed because the provider was unavailable, which
+// are skipped. When every lookup failed, so does findMatch.
+func findMatch(ctx context.Context, provider GeoProvider, g *GeoData, miner MinerData, locations []geodist.Coord, maxDistance, minSimilarity float64) (*GeoMatch, []IPLocation, []checks.ProviderError, error) {
+	name := provider.Name()
+	countryLevel := false
+	if p, ok := provider.(CountryLevelProvider); ok {
+		countryLevel = p.CountryLevel()
+	}
+
+	var found []IPLocation
+	var failed []checks.ProviderError
+	var lastErr error
+	seen := make(map[netip.Addr]bool)
+	for _, m := range g.MultiaddrsIPs {
+		ip := m.addr()
+		if seen[ip] {
 			continue
 		}
-		log.Printf("Matching Geolite2 country for %s (%s) found, IP: %s\n",
-			miner.MinerID, miner.CountryCode, ip)
+		seen[ip] = true
 
-		// Try to match city
-		if geolite2.City == miner.City {
-			log.Printf("Match found! %s matches Geolite2 city name (%s), IP: %s\n",
-				miner.MinerID, miner.City, ip)
-			match_found = true
+		loc, err := provider.Locate(ctx, ip)
+		if checks.KindOf(err) == checks.KindUpstreamUnavailable {
+			log.Printf("Skipping %s lookup of IP %s: %v\n", name, ip, err)
+			failed = append(failed, checks.ProviderError{Provider: name, IP: ip.String(), Error: err.Error()})
+			lastErr = err
 			continue
 		}
-		log.Printf("No Geolite2 city match for %s (%s != GeoLite2:%s), IP: %s\n",
-			miner.MinerID, miner.City, geolite2.City, ip)
-
-		// Try to match based on Lat/Lng
-		l := geolite2.Geolite2["location"].(map[string]interface{})
-		geolite2Location := geodist.Coord{
-			Lat: l["latitude"].(float64),
-			Lon: l["longitude"].(float64),
+		if err != nil {
+			return nil, found, failed, err
 		}
-		log.Printf("Geolite2 Lat/Lng: %v for IP %s\n", geolite2Location, ip)
-
-		// Distance based matching
-		for i, location := range locations {
-			log.Printf("Geocoded via Google %s, %s #%d Lat/Long %v", miner.City,
-				miner.CountryCode, i+1, location)
-			_, distance, err := geodist.VincentyDistance(location, geolite2Location)
-			if err != nil {
-				log.Println("Unable to compute Vincenty Distance.")
-				continue
-			} else {
-				if distance <= MAX_DISTANCE {
-					log.Printf("Match found! Distance %f km\n", distance)
-					match_found = true
-					continue
-				}
-				log.Printf("No match, distance %f km > %d km\n", distance, MAX_DISTANCE)
-			}
+		if loc == nil {
+			continue
 		}
-	}
-	return match_found
-}
+		loc.CountryCode = providerCountry(loc.CountryCode)
+		found = append(found, *loc)
 
-func findMatchGeoIP2(g *GeoData, miner MinerData, locations []geodist.Coord) bool {
-	provisional_match := false
-	match_found := false
-
-	for ip, geoip2 := range g.IPsGeoIP2 {
 		// Match country
-		if geoip2.Country.IsoCode != miner.CountryCode {
-			log.Printf("No GeoIP2 country match for %s (%s != GeoIP2:%s), IP: %s\n",
-				miner.MinerID, miner.CountryCode, geoip2.Country.IsoCode, ip)
+		if loc.CountryCode != miner.CountryCode {
+			log.Printf("No %s country match for %s (%s != %s:%s), IP: %s\n",
+				name, miner.MinerID, miner.CountryCode, name, loc.CountryCode, ip)
 			continue
 		}
-		log.Printf("Matching GeoIP2 country for %s (%s) found, IP: %s\n",
-			miner.MinerID, miner.CountryCode, ip)
+		log.Printf("Matching %s country for %s (%s) found, IP: %s\n",
+			name, miner.MinerID, miner.CountryCode, ip)
 
 		// Try to match city
-		for _, cityName := range geoip2.City.Names {
-			if cityName == miner.City {
-				log.Printf("Match found! %s matches GeoIP2 city name (%s), IP: %s\n",
-					miner.MinerID, miner.City, ip)
-				match_found = true
-				continue
-			}
+		if how, similarity := MatchCity(miner.City, loc.City, minSimilarity); how != "" {
+			log.Printf("Match found! %s matches %s city name (%s ~ %s:%s, %s, %.2f), IP: %s\n",
+				miner.MinerID, name, miner.City, name, loc.City, how, similarity, ip)
+			return matched(loc, GeoMatch{City: loc.City, CityMatch: how, CitySimilarity: similarity}), found, failed, nil
 		}
-		log.Printf("No GeoIP2 city match for %s (%s != GeoIP2:%s), IP: %s\n",
-			miner.MinerID, miner.City, geoip2.City.Names["en"], ip)
-		if geoip2.City.Names["en"] == "" {
-			provisional_match = true
+		log.Printf("No %s city match for %s (%s != %s:%s), IP: %s\n",
+			name, miner.MinerID, miner.City, name, loc.City, ip)
+		if loc.City == "" && countryLevel {
+			log.Printf("Match found! %s has no city for IP %s, country matches\n", name, ip)
+			return matched(loc, GeoMatch{}), found, failed, nil
 		}
 
 		// Try to match based on Lat/Lng
-		geoip2Location := geodist.Coord{
-			Lat: geoip2.Location.Latitude,
-			Lon: geoip2.Location.Longitude,
-		}
-		log.Printf("GeoIP2 Lat/Lng: %v for IP %s\n", geoip2Location, ip)
-
-		// Distance based matching
-		for i, location := range locations {
-			log.Printf("Geocoded via Google %s, %s #%d Lat/Long %v", miner.City,
-				miner.CountryCode, i+1, location)
-			_, distance, err := geodist.VincentyDistance(location, geoip2Location)
-			if err != nil {
-				log.Println("Unable to compute Vincenty Distance.")
-				continue
-			} else {
-				if distance <= MAX_DISTANCE {
-					log.Printf("Match found! Distance %f km\n", distance)
-					match_found = true
-					continue
-				}
-				log.Printf("No match, distance %f km > %d km\n", distance, MAX_DISTANCE)
-			}
-		}
-	}
-	if provisional_match {
-		log.Printf("Match found! %s had GeoIP2 entries that matched country, "+
-			"all with no city data.\n",
-			miner.MinerID)
-		match_found = true
-	}
-	return match_found
-}
-
-func findMatchBaidu(g *GeoData, miner MinerData, locations []geodist.Coord) bool {
-	match_found := false
-	for ip, baidu := range g.IPsBaidu {
-		// Try to match city
-		if baidu.City == miner.City {
-			log.Printf("Match found! %s matches city name (%s), IP: %s\n",
-				miner.MinerID, miner.City, ip)
-			match_found = true
+		if loc.Coord == nil {
+			log.Printf("No %s Lat/Lng for IP %s\n", name, ip)
 			continue
 		}
-		log.Printf("No city match for %s (%s != Baidu:%s), IP: %s\n",
-			miner.MinerID, miner.City, baidu.City, ip)
-
-		baiduContent := baidu.Baidu["content"].(map[string]interface{})
-		baiduPoint := baiduContent["point"].(map[string]interface{})
-		lon, err := strconv.ParseFloat(baiduPoint["x"].(string), 64)
-		if err != nil {
-			log.Println("Error parsing baidu longitude (x)", err)
-			continue
+		system := loc.CoordSystem
+		if system == "" {
+			system = coords.WGS84
 		}
-		lat, err := strconv.ParseFloat(baiduPoint["y"].(string), 64)
+		ipLocation, err := coords.ToWGS84(*loc.Coord, system)
 		if err != nil {
-			log.Println("Error parsing baidu latitude (y)", err)
+			log.Printf("Ignoring %s Lat/Lng for IP %s: %v\n", name, ip, err)
 			continue
 		}
-		baiduLocation := geodist.Coord{
-			Lat: lat,
-			Lon: lon,
-		}
-		log.Printf("Baidu Lat/Lng: %v for IP %s\n", baiduLocation, ip)
+		log.Printf("%s Lat/Lng: %v (%s), %v (WGS-84) for IP %s\n", name, *loc.Coord, system, ipLocation, ip)
+
 		// Distance based matching
 		for i, location := range locations {
-			log.Printf("Geocoded via Google %s, %s #%d Lat/Long %v", miner.City,
+			log.Printf("Geocoded %s, %s #%d Lat/Long %v", miner.City,
 				miner.CountryCode, i+1, location)
-			_, distance, err := geodist.VincentyDistance(location, baiduLocation)
+			_, distance, err := geodist.VincentyDistance(location, ipLocation)
 			if err != nil {
 				log.Println("Unable to compute Vincenty Distance.")
 				continue
-			} else {
-				if distance <= MAX_DISTANCE {
-					log.Printf("Match found! Distance %f km\n", distance)
-					match_found = true
-					continue
-				}
-				log.Printf("No match, distance %f km > %d km\n", distance, MAX_DISTANCE)
 			}
+			if distance <= maxDistance {
+				log.Printf("Match found! Distance %f km\n", distance)
+				return matched(loc, GeoMatch{DistanceKm: &distance}), found, failed, nil
+			}
+			log.Printf("No match, distance %f km > %.0f km\n", distance, maxDistance)
 		}
 	}
-	return match_found
+	if len(failed) > 0 && len(failed) == len(seen) {
+		return nil, found, failed, checks.Errorf(checks.KindUpstreamUnavailable,
+			"every %s lookup failed, last: %w", name, lastErr)
+	}
+	return nil, found, failed, nil
 }
 
 // GeoMatchExists checks if the miner has an IP address with a location close to the city/country
 func GeoMatchExists(
 	ctx context.Context,
 	geodata *GeoData,
-	geocodeClient *maps.Client,
+	geocoder Geocoder,
+	policy checks.GeoPolicy,
 	currentEpoch int64,
 	miner MinerData,
 ) (bool, FinalGeoData, error) {
-	// Quick fixes for bad input data
-	if miner.CountryCode == "United States" || miner.CountryCode == "San Jose, CA" {
-		miner.CountryCode = "US"
-	}
-	if miner.CountryCode == "Canada" {
-		miner.CountryCode = "CA"
+	country, err := ResolveCountry(miner.CountryCode)
+	if err != nil {
+		return false, FinalGeoData{}, err
 	}
-	miner.CountryCode = strings.ToUpper(miner.CountryCode)
+	miner.CountryCode = country.Alpha2
 
 	log.Printf("Searching for geo matches for %s (%s, %s)", miner.MinerID, miner.City, miner.CountryCode)
-	g, err := geodata.filterByMinerID(ctx, miner.MinerID, currentEpoch)
+	g, excluded, err := geodata.filterByMinerID(ctx, miner.MinerID, currentEpoch, policy.IPMaxAgeEpochs)
 	if err != nil {
 		return false, FinalGeoData{}, err
 	}
 
-	data := FinalGeoData{GeoData: g}
+	chain, err := providerChain(g, policy, miner.CountryCode)
 	if err != nil {
-		return false, data, err
+		return false, FinalGeoData{}, err
 	}
 
+	data := FinalGeoData{GeoData: g, ExcludedIPs: excluded}
+
 	if len(g.MultiaddrsIPs) == 0 {
 		log.Printf("No Multiaddrs/IPs found for %s\n", miner.MinerID)
 		return false, data, nil
 	}
 
-	locations, addresses, googleResponse, err := geocodeAddress(ctx, geocodeClient, fmt.Sprintf("%s, %s", miner.City, miner.CountryCode))
+	places, err := geocode(ctx, geocoder, miner.City, miner.CountryCode)
 	if err != nil {
-		log.Fatalf("Geocode error: %s", err)
+		return false, data, err
+	}
+	locations := []geodist.Coord{}
+	for _, p := range places {
+		locations = append(locations, p.Coord)
+		data.GeoDataAddresses = append(data.GeoDataAddresses, p.Address)
+		if p.Google != nil {
+			data.GoogleGeocodeData = append(data.GoogleGeocodeData, *p.Google)
+		}
 	}
 	data.GeocodeLocations = locations
-	data.GeoDataAddresses = addresses
-	data.GoogleGeocodeData = googleResponse
 
-	match_found := false
+	maxDistance := policy.MaxDistanceKmFor(miner.CountryCode)
+	log.Printf("Matching within %.0f km\n", maxDistance)
 
-	// First, try with Baidu data
-	// if miner.CountryCode == "CN" {
-	// 	match_found = findMatchBaidu(g, miner, locations)
-	// }
-
-	// // Next, try with Geolite2 data
-	// if !match_found {
-	// 	match_found = findMatchGeoLite2(g, miner, locations)
-	// }
-
-	// // last, try with GeoIP2 API data
-	// if !match_found {
-	// 	match_found = findMatchGeoIP2(g, miner, locations)
-	// }
-
-	if !match_found {
+	if err := data.matchChain(ctx, chain, miner, locations, maxDistance, policy.MinCitySimilarity); err != nil {
+		return false, data, err
+	}
+	if data.Match == nil {
 		log.Println("No match found.")
 	}
-	return match_found, data, nil
+	return data.Match != nil, data, nil
 }
 
-// returns a mapping of the tmp paths to the geodata downloads
-func getLocationData() ([3]string, error) {
-	var tempDir string
-	if _, err := os.Stat(fmt.Sprintf("/tmp/%s", downloadsDir)); errors.Is(err, os.ErrNotExist) {
-		tempDir, err = ioutil.TempDir("/tmp", downloadsDir)
-		if err != nil {
-			log.Fatal("Failed to create temp dir:", err)
+// matchChain runs findMatch with each provider of chain in turn, until one
+// matches. The lookups that failed because a provider was unavailable are
+// recorded in ProviderErrors and skipped, since the rest of the chain may
+// still match: only when every provider is unavailable is it an error. Any
+// other error, such as a misconfigured provider, stops the chain.
+func (data *FinalGeoData) matchChain(ctx context.Context, chain []GeoProvider, miner MinerData, locations []geodist.Coord, maxDistance, minSimilarity float64) error {
+	unavailable := 0
+	var lastErr error
+	for _, provider := range chain {
+		log.Printf("Trying %s for %s\n", provider.Name(), miner.MinerID)
+		match, found, failed, err := findMatch(ctx, provider, data.GeoData, miner, locations, maxDistance, minSimilarity)
+		data.IPLocations = append(data.IPLocations, found...)
+		data.ProviderErrors = append(data.ProviderErrors, failed...)
+		if checks.KindOf(err) == checks.KindUpstreamUnavailable {
+			log.Printf("Skipping %s for %s: %v\n", provider.Name(), miner.MinerID, err)
+			unavailable++
+			lastErr = err
+			continue
 		}
-	}
-
-	// not necessary as lambda removes all tmp files
-	// TODO maybe make this more persistent cron so lambdas can share the latest data (cache)
-	// defer func() {
-	// 	if _, err := os.Stat(fmt.Sprintf("/tmp/%s", downloadsDir)); errors.Is(err, os.ErrExist) {
-	// 		err := os.RemoveAll(tempDir)
-	// 		if err != nil {
-	// 			log.Fatal("Failed to remove temp dir:", err)
-	// 		}
-	// 	}
-	// }()
-
-	urls := []string{
-		"https://multiaddrs-ips.feeds.provider.quest/multiaddrs-ips-latest.json",
-		"https://geoip.feeds.provider.quest/ips-geolite2-latest.json",
-		"https://geoip.feeds.provider.quest/ips-baidu-latest.json",
-	}
-
-	result := [3]string{}
-
-	for i, dataUrl := range urls {
-		u, err := url.Parse(dataUrl)
 		if err != nil {
-			return [3]string{}, err
+			return err
 		}
-		base := path.Base(u.Path)
-		dest := path.Join(tempDir, base)
-
-		if _, err := os.Stat(dest); errors.Is(err, os.ErrNotExist) {
-			log.Printf("Downloading %s ...\n", base)
-			resp, err := http.Get(dataUrl)
-			if err != nil {
-				return [3]string{}, err
-			}
-			defer resp.Body.Close()
-			out, err := os.Create(dest)
-			if err != nil {
-				return [3]string{}, err
-			}
-			defer out.Close()
-			io.Copy(out, resp.Body)
+		data.Match = match
+		if data.Match != nil {
+			return nil
 		}
-
-		result[i] = dest
 	}
-
-	return result, nil
+	if len(chain) > 0 && unavailable == len(chain) {
+		return checks.Errorf(checks.KindUpstreamUnavailable, "every geolocation provider failed, last: %w", lastErr)
+	}
+	return nil
 }
@@ -3,12 +3,17 @@ package geoip
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain/chaintest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -26,10 +31,52 @@ func TestGeoMatchExists(t *testing.T) {
 	extraArtifacts := os.Getenv("EXTRA_ARTIFACTS")
 
 	var currentEpoch int64
+	var geodata *GeoData
+	var err error
+
+	// Without credentials the GeoIP2 backed cases are skipped, and cities
+	// are geocoded with the gazetteer in testdata rather than Google.
+	if os.Getenv("MAXMIND_USER_ID") == "" || os.Getenv("MAXMIND_LICENSE_KEY") == "" {
+		t.Setenv("MAXMIND_USER_ID", "skip")
+	}
+	if os.Getenv("GOOGLE_MAPS_API_KEY") == "" {
+		t.Setenv("GOOGLE_MAPS_API_KEY", "skip")
+	}
+	if os.Getenv("IPINFO_TOKEN") == "" {
+		t.Setenv("IPINFO_TOKEN", "skip")
+	}
+	if os.Getenv("GAZETTEER_PATH") == "" {
+		t.Setenv("GAZETTEER_PATH", "testdata/cities.txt")
+	}
+
+	policy, err := checks.LoadPolicy()
+	if err != nil {
+		t.Fatalf("Error loading policy: %v\n", err)
+	}
 
 	cases := make([]TestCase, 0)
 	if minerID == "" {
-		currentEpoch = 2055000 // To match JSON files
+		// Hermetic run: the epoch comes from the Lotus stand-in and the
+		// feeds from testdata, all captured around epoch 2055000.
+		client, err := chain.Dial(context.Background(), chain.Config{
+			Endpoint: chaintest.NewServer(t, chaintest.Fixtures),
+		})
+		if err != nil {
+			t.Fatalf("Error connecting to lotus stand-in: %v\n", err)
+		}
+		defer client.Close()
+		currentEpoch, err = GetCurrentEpoch(context.Background(), client)
+		if err != nil {
+			t.Fatalf("Error getting current epoch: %v\n", err)
+		}
+
+		geodata, err = LoadGeoDataFiles(
+			"testdata/multiaddrs-ips-latest.json",
+			"testdata/ips-geolite2-latest.json",
+			"testdata/ips-baidu-latest.json",
+		)
+		assert.Nil(t, err)
+
 		cases = append(
 			cases,
 			TestCase{
@@ -50,105 +97,105 @@ func TestGeoMatchExists(t *testing.T) {
 				countryCode: "CN",
 				want:        false,
 			},
+			TestCase{ // US - City Name match
+				minerID:     "f01873432",
+				city:        "Las Vegas",
+				countryCode: "US",
+				want:        true,
+			},
+			TestCase{ // Bad data for country
+				minerID:     "f01873432",
+				city:        "Las Vegas",
+				countryCode: "United States",
+				want:        true,
+			},
+			TestCase{ // China - City Name match
+				minerID:     "f01012",
+				city:        "Hangzhou",
+				countryCode: "CN",
+				want:        true,
+			},
+			TestCase{ // China - City Name match, normalized
+				minerID:     "f01012",
+				city:        "hangzhou shi",
+				countryCode: "CN",
+				want:        true,
+			},
+			TestCase{ // China - City Name match, lowercase country code
+				minerID:     "f01012",
+				city:        "Hangzhou",
+				countryCode: "cn",
+				want:        true,
+			},
+			TestCase{ // China - GeoLite2, no Baidu
+				minerID:     "f01901765",
+				city:        "Hangzhou",
+				countryCode: "CN",
+				want:        true,
+			},
+			TestCase{ // Distance match, 500km
+				minerID:     "f01558688",
+				city:        "Montreal",
+				countryCode: "CA",
+				want:        true,
+			},
+			TestCase{ // More than 500 km
+				minerID:     "f01558688",
+				city:        "Vancouver",
+				countryCode: "CA",
+				want:        false,
+			},
+			TestCase{ // China - Distance match
+				minerID:     "f01012",
+				city:        "Jiaxing",
+				countryCode: "CN",
+				want:        true,
+			},
+			TestCase{ // No GeoLite2 city, distance match
+				minerID:     "f01736668",
+				city:        "Omaha",
+				countryCode: "US",
+				want:        true,
+			},
+			TestCase{ // Bad data for country
+				minerID:     "f01558688",
+				city:        "Montreal",
+				countryCode: "Canada",
+				want:        true,
+			},
 		)
-		if os.Getenv("MAXMIND_USER_ID") == "skip" {
-			log.Println("Warning: Skipping tests as MAXMIND_USER_ID set to 'skip'")
-		} else {
-			cases = append(
-				cases,
-				TestCase{ // No GeoLite2 city, but has GeoIP2 data
-					minerID:     "f01736668",
-					city:        "Omaha",
-					countryCode: "US",
-					want:        true,
-				},
-				TestCase{ // Has GeoIP2 data, but no city
-					minerID:     "f01873432",
-					city:        "Las Vegas",
-					countryCode: "US",
-					want:        true,
-				},
-				TestCase{ // Bad data for country
-					minerID:     "f01873432",
-					city:        "Las Vegas",
-					countryCode: "United States",
-					want:        true,
-				},
-				TestCase{ // Bad data for country
-					minerID:     "f01558688",
-					city:        "Montreal",
-					countryCode: "Canada",
-					want:        true,
-				},
-			)
-		}
-		if os.Getenv("GOOGLE_MAPS_API_KEY") == "skip" {
-			log.Println("Warning: Skipping tests as GOOGLE_MAP_API_KEY set to 'skip'")
-		} else {
-			cases = append(
-				cases,
-				TestCase{ // Distance match, 500km
-					minerID:     "f01558688",
-					city:        "Montreal",
-					countryCode: "CA",
-					want:        true,
-				},
-				TestCase{ // More than 500 km
-					minerID:     "f01558688",
-					city:        "Vancouver",
-					countryCode: "CA",
-					want:        false,
-				},
-				TestCase{ // China - City Name match
-					minerID:     "f01012",
-					city:        "Hangzhou",
-					countryCode: "CN",
-					want:        true,
-				},
-				TestCase{ // China - City Name match, lowercase country code
-					minerID:     "f01012",
-					city:        "Hangzhou",
-					countryCode: "cn",
-					want:        true,
-				},
-				TestCase{ // China - Distance match
-					minerID:     "f01012",
-					city:        "Jiaxing",
-					countryCode: "CN",
-					want:        true,
-				},
-				TestCase{ // China - GeoLite2, no Baidu
-					minerID:     "f01901765",
-					city:        "Hangzhou",
-					countryCode: "CN",
-					want:        true,
-				},
-			)
-		}
 	} else {
 		var err error
 		currentEpoch, err = strconv.ParseInt(os.Getenv("EPOCH"), 10, 64)
 		if currentEpoch == 0 || err != nil {
-			currentEpoch, err = GetCurrentEpoch(context.Background())
+			cfg, err := chain.ConfigFromEnv()
+			assert.Nil(t, err)
+			client, err := chain.Dial(context.Background(), cfg)
 			if err != nil {
-				log.Fatalf("Error getting current epoch: %v\n", err)
+				t.Fatalf("Error connecting to lotus: %v\n", err)
+			}
+			defer client.Close()
+			currentEpoch, err = GetCurrentEpoch(context.Background(), client)
+			if err != nil {
+				t.Fatalf("Error getting current epoch: %v\n", err)
 			}
 		}
 
 		cases = append(cases, TestCase{minerID, city, countryCode, true})
-	}
 
-	geodata, err := LoadGeoData()
-	assert.Nil(t, err)
+		geodata, err = LoadGeoData(context.Background(), policy.Feeds)
+		assert.Nil(t, err)
+	}
 
-	geocodeClient, err := GetGeocodeClient()
+	geocoder, err := GetGeocoder()
 	assert.Nil(t, err)
 
 	for _, c := range cases {
 		ok, extra, err := GeoMatchExists(
 			context.Background(),
 			geodata,
-			geocodeClient,
+			geocoder,
+			policy.Geo,
 			currentEpoch,
 			MinerData{
 				c.minerID,
@@ -157,8 +204,7 @@ func TestGeoMatchExists(t *testing.T) {
 			},
 		)
 		assert.Nil(t, err)
-		assert.NotNil(t, ok)
-		// assert.Equal(t, c.want, ok)
+		assert.Equal(t, c.want, ok, "%s (%s, %s)", c.minerID, c.city, c.countryCode)
 		if extraArtifacts != "" {
 			extraJson, err := json.MarshalIndent(extra, "", "  ")
 			assert.Nil(t, err)
@@ -166,3 +212,146 @@ func TestGeoMatchExists(t *testing.T) {
 		}
 	}
 }
+
+func TestGeoProviderChain(t *testing.T) {
+	t.Setenv("MAXMIND_USER_ID", "skip")
+	t.Setenv("IPINFO_TOKEN", "skip")
+
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	const epoch = 2055000
+
+	cases := []struct {
+		name      string
+		providers []checks.GeoProviderPolicy
+		miner     MinerData
+		want      string
+	}{
+		{
+			name:  "baidu first in China",
+			miner: MinerData{"f01012", "Hangzhou", "CN"},
+			want:  "baidu",
+		},
+		{
+			name:      "baidu restricted to China",
+			providers: []checks.GeoProviderPolicy{{Name: "baidu", Countries: []string{"CN"}}},
+			miner:     MinerData{"f02620", "Warsaw", "PL"},
+		},
+		{
+			name:      "short-circuits on the first match",
+			providers: []checks.GeoProviderPolicy{{Name: "geolite2"}, {Name: "baidu"}},
+			miner:     MinerData{"f01901765", "Hangzhou", "CN"},
+			want:      "geolite2",
+		},
+		{
+			name:      "only listed providers run",
+			providers: []checks.GeoProviderPolicy{{Name: "geolite2"}},
+			miner:     MinerData{"f01012", "Hangzhou", "CN"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			geo := policy.Geo
+			if c.providers != nil {
+				geo.Providers = c.providers
+			}
+			ok, data, err := GeoMatchExists(context.Background(), geodata, nil, geo, epoch, c.miner)
+			assert.Nil(t, err)
+			assert.Equal(t, c.want != "", ok)
+			if c.want != "" && assert.NotNil(t, data.Match) {
+				assert.Equal(t, c.want, data.Match.Provider)
+			}
+		})
+	}
+
+	geo := policy.Geo
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "carrier-pigeon"}}
+	_, _, err = GeoMatchExists(context.Background(), geodata, nil, geo, epoch, MinerData{"f02620", "Warsaw", "PL"})
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+}
+
+func TestReadGeoData(t *testing.T) {
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2022, 8, 7, 22, 40, 11, 103e6, time.UTC), geodata.Dates.MultiaddrsIPs.UTC())
+	assert.Equal(t, time.Date(2022, 8, 7, 22, 45, 2, 771e6, time.UTC), geodata.Dates.IPsGeolite2.UTC())
+	assert.Equal(t, time.Date(2022, 8, 7, 22, 49, 44, 248e6, time.UTC), geodata.Dates.IPsBaidu.UTC())
+
+	bad := []string{
+		`{"date": "2022-08-07T22:40:11.103Z", "multiaddrsIps": [`,
+		`{"date": "2022-08-07T22:40:11.103Z", "multiaddrsIps": {}}`,
+		`{"multiaddrsIps": []}`,
+		`{"date": "yesterday", "multiaddrsIps": []}`,
+		`{"date": "2022-08-07T22:40:11.103Z"}`,
+	}
+	for _, feed := range bad {
+		_, err := ReadMultiAddrsIPs(strings.NewReader(feed))
+		assert.NotNil(t, err, feed)
+	}
+	_, err = ReadIPsGeolite2(strings.NewReader(`{"date": "2022-08-07T22:45:02.771Z", "ipsGeolite2": []}`))
+	assert.NotNil(t, err)
+	_, err = ReadIPsBaidu(strings.NewReader(`{"date": "2022-08-07T22:49:44.248Z"}`))
+	assert.NotNil(t, err)
+
+	report, err := ReadIPsBaidu(strings.NewReader(`{"date": "2022-08-07T22:49:44.248Z", "ipsBaidu": {}}`))
+	assert.Nil(t, err)
+	assert.Empty(t, report.IPs)
+}
+
+func TestStaleFeeds(t *testing.T) {
+	generated := time.Date(2022, 8, 7, 22, 0, 0, 0, time.UTC)
+	dates := FeedDates{
+		MultiaddrsIPs: generated,
+		IPsGeolite2:   generated.Add(-48 * time.Hour),
+		IPsBaidu:      generated,
+	}
+	clock := chain.NewClock(chain.Mainnet)
+	epoch := int64(clock.EpochAt(generated.Add(48 * time.Hour)))
+
+	policy := func(staleness string, action checks.StaleAction) *checks.State {
+		p, err := checks.ParsePolicy([]byte(fmt.Sprintf(`{
+			"version": "test",
+			"min_power": "0",
+			"geo": {"max_distance_km": 1, "ip_max_age_epochs": 1},
+			"feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c",
+				"max_staleness": %q, "on_stale": %q}
+		}`, staleness, action)))
+		assert.Nil(t, err)
+		return &checks.State{Policy: p, Clock: clock}
+	}
+
+	stale, err := staleFeeds(dates, policy("72h", checks.FlagStaleFeeds), epoch)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ips_geolite2"}, stale)
+
+	stale, err = staleFeeds(dates, policy("24h", checks.FlagStaleFeeds), epoch)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"multiaddrs_ips", "ips_geolite2", "ips_baidu"}, stale)
+
+	_, err = staleFeeds(dates, policy("72h", checks.RejectStaleFeeds), epoch)
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+	assert.Contains(t, err.Error(), "ips_geolite2 generated 2022-08-05T22:00:00Z")
+
+	stale, err = staleFeeds(dates, policy("168h", checks.RejectStaleFeeds), epoch)
+	assert.Nil(t, err)
+	assert.Empty(t, stale)
+
+	// Not checked without a max staleness
+	stale, err = staleFeeds(dates, policy("", checks.RejectStaleFeeds), epoch)
+	assert.Nil(t, err)
+	assert.Empty(t, stale)
+
+	assert.Equal(t, generated, dates.byPolicyName()["ips_baidu"])
+}
@@ -0,0 +1,224 @@
+package geoip
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// CityMatch is how a provider's city name matched the miner's.
+type CityMatch string
+
+const (
+	// CityExact names are the same string.
+	CityExact CityMatch = "exact"
+	// CityNormalized names are the same once normalized by NormalizeCity.
+	CityNormalized CityMatch = "normalized"
+	// CityFuzzy names are similar enough once normalized, e.g. a
+	// transliteration the aliases don't know.
+	CityFuzzy CityMatch = "fuzzy"
+)
+
+// letterFolds transliterates the Latin letters that don't decompose into a
+// base letter and diacritics.
+var letterFolds = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'œ': "oe", 'ø': "o", 'ł': "l", 'đ': "d",
+	'ð': "d", 'þ': "th", 'ı': "i", 'ħ': "h",
+}
+
+// tokenRewrites expands the abbreviations common in city names.
+var tokenRewrites = map[string]string{
+	"st":  "saint",
+	"ste": "sainte",
+	"ft":  "fort",
+	"mt":  "mount",
+}
+
+// cityAliases maps other names and transliterations of cities, normalized,
+// to their usual English name, normalized.
+var cityAliases = map[string]string{
+	"nyc":               "new york",
+	"la":                "los angeles",
+	"sf":                "san francisco",
+	"washington dc":     "washington",
+	"dc":                "washington",
+	"frankfurt am main": "frankfurt",
+	"den haag":          "the hague",
+	"s gravenhage":      "the hague",
+	"hongkong":          "hong kong",
+	"muenchen":          "munich",
+	"munchen":           "munich",
+	"koeln":             "cologne",
+	"koln":              "cologne",
+	"wien":              "vienna",
+	"praha":             "prague",
+	"warszawa":          "warsaw",
+	"moskva":            "moscow",
+	"kiev":              "kyiv",
+	"roma":              "rome",
+	"milano":            "milan",
+	"lisboa":            "lisbon",
+	"bombay":            "mumbai",
+	"calcutta":          "kolkata",
+	"madras":            "chennai",
+	"bangalore":         "bengaluru",
+	"saigon":            "ho chi minh",
+	"peking":            "beijing",
+	"canton":            "guangzhou",
+	"xi an":             "xian",
+	"北京":                "beijing",
+	"上海":                "shanghai",
+	"天津":                "tianjin",
+	"重庆":                "chongqing",
+	"杭州":                "hangzhou",
+	"嘉兴":                "jiaxing",
+	"苏州":                "suzhou",
+	"南京":                "nanjing",
+	"深圳":                "shenzhen",
+	"广州":                "guangzhou",
+	"成都":                "chengdu",
+	"武汉":                "wuhan",
+	"西安":                "xian",
+	"香港":                "hong kong",
+	"台北":                "taipei",
+	"東京":                "tokyo",
+	"东京":                "tokyo",
+	"大阪":                "osaka",
+	"서울":                "seoul",
+}
+
+// NormalizeCity folds a city name to the form names are compared in:
+// Unicode compatibility forms, diacritics, case and punctuation are folded,
+// a trailing region such as ", CA" is dropped, suffixes such as "City" and
+// "市" are stripped, and known aliases are replaced by the usual English
+// name.
+func NormalizeCity(name string) string {
+	if i := strings.IndexAny(name, ",，("); i > 0 {
+		name = name[:i]
+	}
+
+	tokens := strings.Fields(foldName(name))
+	for i, t := range tokens {
+		if r, ok := tokenRewrites[t]; ok {
+			tokens[i] = r
+		}
+	}
+	if len(tokens) > 2 && tokens[0] == "city" && tokens[1] == "of" {
+		tokens = tokens[2:]
+	}
+	if n := len(tokens); n > 1 && (tokens[n-1] == "city" || tokens[n-1] == "shi") {
+		tokens = tokens[:n-1]
+	}
+	if n := len(tokens); n > 0 {
+		last := []rune(tokens[n-1])
+		if len(last) > 1 && last[len(last)-1] == '市' {
+			tokens[n-1] = string(last[:len(last)-1])
+		}
+	}
+
+	normalized := strings.Join(tokens, " ")
+	if alias, ok := cityAliases[normalized]; ok {
+		return alias
+	}
+	return normalized
+}
+
+// foldName folds Unicode compatibility forms, diacritics, case and
+// punctuation out of a place name, leaving lowercase words separated by
+// single spaces.
+func foldName(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFKD.String(name) {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			// Diacritics, split from their letters by NFKD
+		case r == '\'' || r == '’' || r == '‘' || r == '`' || r == '.':
+			// Xi'an is Xian, St. is St
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			r = unicode.ToLower(r)
+			if s, ok := letterFolds[r]; ok {
+				b.WriteString(s)
+			} else {
+				b.WriteRune(r)
+			}
+		default:
+			b.WriteRune(' ')
+		}
+	}
+	// NFC puts Hangul, split into jamo by NFKD, back together
+	return strings.Join(strings.Fields(norm.NFC.String(b.String())), " ")
+}
+
+// nameSimilarity is one less the edit distance between a and b over the
+// length of the longer, from 0 for nothing in common to 1 for the same.
+func nameSimilarity(a, b string) float64 {
+	ra, rb := []rune(a), []rune(b)
+	longest := len(ra)
+	if len(rb) > longest {
+		longest = len(rb)
+	}
+	if longest == 0 {
+		return 0
+	}
+	return 1 - float64(editDistance(ra, rb))/float64(longest)
+}
+
+// CitySimilarity scores how alike two city names are once normalized, from
+// 0 for nothing in common to 1 for the same name, as one less the edit
+// distance over the length of the longer name.
+func CitySimilarity(a, b string) float64 {
+	return nameSimilarity(NormalizeCity(a), NormalizeCity(b))
+}
+
+// editDistance is the Levenshtein distance between a and b.
+func editDistance(a, b []rune) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// MatchCity compares the city a provider located an IP in, got, with the
+// one the miner claims, want. It returns how they matched, or "" when they
+// don't, and their similarity. Names at least minSimilarity alike match
+// fuzzily.
+func MatchCity(want, got string, minSimilarity float64) (CityMatch, float64) {
+	if strings.TrimSpace(want) == "" || strings.TrimSpace(got) == "" {
+		return "", 0
+	}
+	if want == got {
+		return CityExact, 1
+	}
+	if NormalizeCity(want) == NormalizeCity(got) {
+		return CityNormalized, 1
+	}
+	similarity := CitySimilarity(want, got)
+	if similarity > 0 && similarity >= minSimilarity {
+		return CityFuzzy, similarity
+	}
+	return "", similarity
+}
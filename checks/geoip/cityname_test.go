@@ -0,0 +1,84 @@
+package geoip
+
+import (
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeCity(t *testing.T) {
+	cases := map[string]string{
+		"Hangzhou":             "hangzhou",
+		" hangzhou ":           "hangzhou",
+		"HANGZHOU":             "hangzhou",
+		"Hangzhou Shi":         "hangzhou",
+		"杭州市":                  "hangzhou",
+		"杭州":                   "hangzhou",
+		"Montréal":             "montreal",
+		"Ｍｏｎｔｒｅａｌ":             "montreal",
+		"Xi'an":                "xian",
+		"Xi’an":                "xian",
+		"Xi An":                "xian",
+		"西安市":                  "xian",
+		"NYC":                  "new york",
+		"New York City":        "new york",
+		"New York, NY":         "new york",
+		"San Jose, CA":         "san jose",
+		"San José":             "san jose",
+		"St. Louis":            "saint louis",
+		"Saint-Louis":          "saint louis",
+		"Frankfurt am Main":    "frankfurt",
+		"München":              "munich",
+		"Muenchen":             "munich",
+		"Kraków":               "krakow",
+		"Łódź":                 "lodz",
+		"Düsseldorf":           "dusseldorf",
+		"Ho Chi Minh City":     "ho chi minh",
+		"City of London":       "london",
+		"Kansas City":          "kansas",
+		"서울":                   "seoul",
+		"Quebec City (Québec)": "quebec",
+		"":                     "",
+	}
+	for name, want := range cases {
+		assert.Equal(t, want, NormalizeCity(name), name)
+	}
+
+	// Aliases map normalized names to normalized names.
+	for alias, name := range cityAliases {
+		assert.Equal(t, name, NormalizeCity(alias), alias)
+		assert.Equal(t, name, NormalizeCity(name), name)
+	}
+}
+
+func TestMatchCity(t *testing.T) {
+	cases := []struct {
+		want, got  string
+		match      CityMatch
+		similarity float64
+	}{
+		{"Hangzhou", "Hangzhou", CityExact, 1},
+		{"hangzhou", "Hangzhou", CityNormalized, 1},
+		{"Montréal", "Montreal", CityNormalized, 1},
+		{"Xi'an", "Xian", CityNormalized, 1},
+		{"NYC", "New York", CityNormalized, 1},
+		{"San Jose, CA", "San Jose", CityNormalized, 1},
+		{"Hangzhou City", "杭州市", CityNormalized, 1},
+		{"Zhengzhou", "Zhengzhuo", "", 1 - 2.0/9},
+		{"Guangzhou", "Guangzou", CityFuzzy, 1 - 1.0/9},
+		{"Hangzhou", "Huzhou", "", 1 - 3.0/8},
+		{"Vancouver", "Victoria", "", 1 - 7.0/9},
+		{"Hangzhou", "", "", 0},
+		{"", "", "", 0},
+	}
+	for _, c := range cases {
+		match, similarity := MatchCity(c.want, c.got, checks.DefaultMinCitySimilarity)
+		assert.Equal(t, c.match, match, "%s ~ %s", c.want, c.got)
+		assert.InDelta(t, c.similarity, similarity, 1e-9, "%s ~ %s", c.want, c.got)
+	}
+
+	// A similarity of 1 only allows exact and normalized matches.
+	match, _ := MatchCity("Guangzhou", "Guangzou", 1)
+	assert.Equal(t, CityMatch(""), match)
+}
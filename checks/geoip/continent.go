@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+)
+
+// Continent is one of the seven continents, by the two letter code the
+// country-to-continent table uses.
+type Continent struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+// Continents are the continents the country-to-continent table assigns.
+// Antarctica, AN, also holds the sub-Antarctic islands, and Oceania, OC,
+// holds Australia and the Pacific islands.
+var Continents = []Continent{
+	{"AF", "Africa"},
+	{"AN", "Antarctica"},
+	{"AS", "Asia"},
+	{"EU", "Europe"},
+	{"NA", "North America"},
+	{"OC", "Oceania"},
+	{"SA", "South America"},
+}
+
+// continentAliases are the other names of continents, folded.
+var continentAliases = map[string]string{
+	"antarctic":             "AN",
+	"australia and oceania": "OC",
+	"australia oceania":     "OC",
+	"australasia":           "OC",
+	"oceanie":               "OC",
+}
+
+// continents is the table in CountryToContinentJSON, indexed on first use.
+var continents struct {
+	sync.Once
+	byCountry map[string]string
+	byCode    map[string]Continent
+	err       error
+}
+
+func loadContinents() error {
+	continents.Do(func() {
+		var byCountry map[string]string
+		if err := json.Unmarshal(CountryToContinentJSON, &byCountry); err != nil {
+			continents.err = fmt.Errorf("parsing country-to-continent table: %w", err)
+			return
+		}
+		continents.byCountry = byCountry
+		continents.byCode = make(map[string]Continent, len(Continents))
+		for _, c := range Continents {
+			continents.byCode[c.Code] = c
+		}
+		for country, code := range byCountry {
+			if _, ok := continents.byCode[code]; !ok {
+				continents.err = fmt.Errorf("country-to-continent table: unknown continent %q for %s", code, country)
+				return
+			}
+		}
+	})
+	return continents.err
+}
+
+// CountryContinent returns the continent of a country, given as anything
+// ResolveCountry accepts.
+func CountryContinent(country string) (Continent, error) {
+	if err := loadContinents(); err != nil {
+		return Continent{}, checks.Errorf(checks.KindInternal, "%w", err)
+	}
+	c, err := ResolveCountry(country)
+	if err != nil {
+		return Continent{}, err
+	}
+	code, ok := continents.byCountry[c.Alpha2]
+	if !ok {
+		return Continent{}, checks.Errorf(checks.KindInternal, "no continent for %s (%s)", c.Name, c.Alpha2)
+	}
+	return continents.byCode[code], nil
+}
+
+// ResolveContinent finds the continent input names, by code or name,
+// regardless of case.
+func ResolveContinent(input string) (Continent, error) {
+	if err := loadContinents(); err != nil {
+		return Continent{}, checks.Errorf(checks.KindInternal, "%w", err)
+	}
+	if c, ok := continents.byCode[strings.ToUpper(strings.TrimSpace(input))]; ok {
+		return c, nil
+	}
+	folded := foldName(input)
+	if code, ok := continentAliases[folded]; ok {
+		return continents.byCode[code], nil
+	}
+	for _, c := range Continents {
+		if foldName(c.Name) == folded {
+			return c, nil
+		}
+	}
+	return Continent{}, checks.Errorf(checks.KindInvalidInput, "unknown continent %q", input)
+}
@@ -0,0 +1,124 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain/chaintest"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountryContinent(t *testing.T) {
+	cases := map[string]Continent{
+		"PL":            {"EU", "Europe"},
+		"China":         {"AS", "Asia"},
+		"usa":           {"NA", "North America"},
+		"Brasil":        {"SA", "South America"},
+		"NG":            {"AF", "Africa"},
+		"Australia":     {"OC", "Oceania"},
+		"NZ":            {"OC", "Oceania"},
+		"Antarctica":    {"AN", "Antarctica"},
+		"Bouvet Island": {"AN", "Antarctica"},
+		"HM":            {"AN", "Antarctica"},
+		"XK":            {"EU", "Europe"},
+	}
+	for country, want := range cases {
+		c, err := CountryContinent(country)
+		assert.Nil(t, err, country)
+		assert.Equal(t, want, c, country)
+	}
+
+	_, err := CountryContinent("Atlantis")
+	assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err))
+
+	// Every country has a continent.
+	var table []Country
+	assert.Nil(t, json.Unmarshal(ISO3166JSON, &table))
+	for _, country := range table {
+		c, err := CountryContinent(country.Alpha2)
+		assert.Nil(t, err, country.Alpha2)
+		assert.NotEmpty(t, c.Code, country.Alpha2)
+		assert.NotEmpty(t, c.Name, country.Alpha2)
+	}
+}
+
+func TestResolveContinent(t *testing.T) {
+	cases := map[string]string{
+		"EU":            "EU",
+		"oc":            "OC",
+		"Oceania":       "OC",
+		"Australasia":   "OC",
+		"antarctica":    "AN",
+		"AN":            "AN",
+		"North America": "NA",
+		"south-america": "SA",
+	}
+	for input, want := range cases {
+		c, err := ResolveContinent(input)
+		assert.Nil(t, err, input)
+		assert.Equal(t, want, c.Code, input)
+	}
+
+	for _, input := range []string{"", "Australia", "Atlantis", "XX"} {
+		_, err := ResolveContinent(input)
+		assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err), input)
+	}
+}
+
+func TestDoCheckLocContinent(t *testing.T) {
+	t.Setenv("GOOGLE_MAPS_API_KEY", "skip")
+	t.Setenv("MAXMIND_USER_ID", "skip")
+	t.Setenv("IPINFO_TOKEN", "skip")
+	t.Setenv("EPOCH", "")
+
+	srv := newFeedServer(t)
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	policy.Feeds = srv.policy("")
+
+	// The testdata feeds were captured around epoch 2055000.
+	clock := &chain.Clock{Network: chain.Mainnet}
+	now := clock.EpochTime(2055000)
+	clock.Now = func() time.Time { return now }
+
+	cases := []struct {
+		minerID, city, country string
+		locCountry, continent  string
+	}{
+		{"f02620", "Warsaw", "PL", "PL", "EU"},
+		{"f02620", "Warsaw", "Poland", "PL", "EU"},
+		{"f01012", "Hangzhou", "cn", "CN", "AS"},
+		{"f01873432", "Las Vegas", "United States", "US", "NA"},
+	}
+	for _, gazetteer := range []string{"", "testdata/cities.txt"} {
+		t.Setenv("GAZETTEER_PATH", gazetteer)
+		lasVegas := "Las Vegas"
+		if gazetteer != "" {
+			lasVegas = "Las Vegas, NV"
+		}
+		for _, c := range cases {
+			state := &checks.State{
+				Lotus:  &chaintest.Fake{},
+				Clock:  clock,
+				Policy: policy,
+			}
+			result, err := (&GeoIPCheck{}).DoCheck(context.Background(), checks.FormSubmission{
+				MinerID: c.minerID,
+				City:    c.city,
+				Country: c.country,
+			}, state)
+			assert.NoError(t, err, c.minerID)
+			assert.Equal(t, checks.StatusPass, result.Status, c.minerID)
+			assert.NotEmpty(t, result.Miner.LocCity, c.minerID)
+			assert.Equal(t, c.locCountry, result.Miner.LocCountry, c.minerID)
+			assert.Equal(t, c.continent, result.Miner.LocContinent, c.minerID)
+			if c.city == "Las Vegas" {
+				assert.Equal(t, lasVegas, result.Miner.LocCity)
+			}
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package coords converts between the coordinate systems geolocation
+// providers report in: WGS-84 used by GPS and most providers, GCJ-02
+// mandated for maps of mainland China, and Baidu's BD-09, in degrees or
+// Mercator projected.
+package coords
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/jftuga/geodist"
+)
+
+// System identifies a coordinate system.
+type System string
+
+const (
+	WGS84 System = "wgs84"
+	GCJ02 System = "gcj02"
+	BD09  System = "bd09"
+	// BD09MC is BD-09 projected to Baidu's Mercator, in meters: Lon holds x
+	// and Lat holds y.
+	BD09MC System = "bd09mc"
+)
+
+// Convert converts c from one system to another, through GCJ-02.
+func Convert(c geodist.Coord, from, to System) (geodist.Coord, error) {
+	if from == to {
+		return c, nil
+	}
+
+	var gcj geodist.Coord
+	switch from {
+	case WGS84:
+		gcj = WGS84ToGCJ02(c)
+	case GCJ02:
+		gcj = c
+	case BD09:
+		gcj = BD09ToGCJ02(c)
+	case BD09MC:
+		gcj = BD09ToGCJ02(BD09MCToBD09(c))
+	default:
+		return c, fmt.Errorf("coords: unknown coordinate system %q", from)
+	}
+
+	switch to {
+	case WGS84:
+		return GCJ02ToWGS84(gcj), nil
+	case GCJ02:
+		return gcj, nil
+	case BD09:
+		return GCJ02ToBD09(gcj), nil
+	case BD09MC:
+		return BD09ToBD09MC(GCJ02ToBD09(gcj)), nil
+	default:
+		return c, fmt.Errorf("coords: unknown coordinate system %q", to)
+	}
+}
+
+// ToWGS84 converts c from the given system to WGS-84.
+func ToWGS84(c geodist.Coord, from System) (geodist.Coord, error) {
+	return Convert(c, from, WGS84)
+}
+
+// IsMercator reports whether a Baidu point is out of range for degrees, and
+// so must be BD09MC.
+func IsMercator(c geodist.Coord) bool {
+	return math.Abs(c.Lon) > 180 || math.Abs(c.Lat) > 90
+}
+
+// OutOfChina reports whether c lies outside the rough bounding box GCJ-02
+// applies to. Points outside it are the same in WGS-84 and GCJ-02.
+func OutOfChina(c geodist.Coord) bool {
+	return c.Lon < 72.004 || c.Lon > 137.8347 || c.Lat < 0.8293 || c.Lat > 55.8271
+}
+
+// Krasovsky 1940 ellipsoid, which GCJ-02 is defined on.
+const (
+	krasovskyA  = 6378245.0
+	krasovskyEE = 0.00669342162296594323
+)
+
+func gcjOffset(c geodist.Coord) (dLat, dLon float64) {
+	x, y := c.Lon-105.0, c.Lat-35.0
+
+	dLat = -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
+	dLat += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	dLat += (20.0*math.Sin(y*math.Pi) + 40.0*math.Sin(y/3.0*math.Pi)) * 2.0 / 3.0
+	dLat += (160.0*math.Sin(y/12.0*math.Pi) + 320*math.Sin(y*math.Pi/30.0)) * 2.0 / 3.0
+
+	dLon = 300.0 + x + 2.0*y + 0.1*x*x + 0.1*x*y + 0.1*math.Sqrt(math.Abs(x))
+	dLon += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	dLon += (20.0*math.Sin(x*math.Pi) + 40.0*math.Sin(x/3.0*math.Pi)) * 2.0 / 3.0
+	dLon += (150.0*math.Sin(x/12.0*math.Pi) + 300.0*math.Sin(x/30.0*math.Pi)) * 2.0 / 3.0
+
+	radLat := c.Lat / 180.0 * math.Pi
+	magic := 1 - krasovskyEE*math.Sin(radLat)*math.Sin(radLat)
+	sqrtMagic := math.Sqrt(magic)
+	dLat = (dLat * 180.0) / ((krasovskyA * (1 - krasovskyEE)) / (magic * sqrtMagic) * math.Pi)
+	dLon = (dLon * 180.0) / (krasovskyA / sqrtMagic * math.Cos(radLat) * math.Pi)
+	return dLat, dLon
+}
+
+// WGS84ToGCJ02 applies the GCJ-02 obfuscation.
+func WGS84ToGCJ02(c geodist.Coord) geodist.Coord {
+	if OutOfChina(c) {
+		return c
+	}
+	dLat, dLon := gcjOffset(c)
+	return geodist.Coord{Lat: c.Lat + dLat, Lon: c.Lon + dLon}
+}
+
+// GCJ02ToWGS84 inverts WGS84ToGCJ02 by fixed point iteration, to well under
+// a millimeter.
+func GCJ02ToWGS84(c geodist.Coord) geodist.Coord {
+	if OutOfChina(c) {
+		return c
+	}
+	wgs := c
+	for i := 0; i < 10; i++ {
+		gcj := WGS84ToGCJ02(wgs)
+		dLat, dLon := gcj.Lat-c.Lat, gcj.Lon-c.Lon
+		wgs = geodist.Coord{Lat: wgs.Lat - dLat, Lon: wgs.Lon - dLon}
+		if math.Abs(dLat) < 1e-10 && math.Abs(dLon) < 1e-10 {
+			break
+		}
+	}
+	return wgs
+}
+
+const bdXPi = math.Pi * 3000.0 / 180.0
+
+// GCJ02ToBD09 applies Baidu's additional BD-09 offset.
+func GCJ02ToBD09(c geodist.Coord) geodist.Coord {
+	x, y := c.Lon, c.Lat
+	z := math.Sqrt(x*x+y*y) + 0.00002*math.Sin(y*bdXPi)
+	theta := math.Atan2(y, x) + 0.000003*math.Cos(x*bdXPi)
+	return geodist.Coord{Lat: z*math.Sin(theta) + 0.006, Lon: z*math.Cos(theta) + 0.0065}
+}
+
+// BD09ToGCJ02 removes the BD-09 offset.
+func BD09ToGCJ02(c geodist.Coord) geodist.Coord {
+	x, y := c.Lon-0.0065, c.Lat-0.006
+	z := math.Sqrt(x*x+y*y) - 0.00002*math.Sin(y*bdXPi)
+	theta := math.Atan2(y, x) - 0.000003*math.Cos(x*bdXPi)
+	return geodist.Coord{Lat: z * math.Sin(theta), Lon: z * math.Cos(theta)}
+}
+
+// Bands and polynomial coefficients of Baidu's Mercator projection, from
+// the Baidu Maps JavaScript API.
+var (
+	mcBand = []float64{12890594.86, 8362377.87, 5591021, 3481989.83, 1678043.12, 0}
+	llBand = []float64{75, 60, 45, 30, 15, 0}
+
+	mc2ll = [][10]float64{
+		{1.410526172116255e-8, 0.00000898305509648872, -1.9939833816331, 200.9824383106796, -187.2403703815547, 91.6087516669843, -23.38765649603339, 2.57121317296198, -0.03801003308653, 17337981.2},
+		{-7.435856389565537e-9, 0.000008983055097726239, -0.78625201886289, 96.32687599759846, -1.85204757529826, -59.36935905485877, 47.40033549296737, -16.50741931063887, 2.28786674699375, 10260144.86},
+		{-3.030883460898826e-8, 0.00000898305509983578, 0.30071316287616, 59.74293618442277, 7.357984074871, -25.38371002664745, 13.45380521110908, -3.29883767235584, 0.32710905363475, 6856817.37},
+		{-1.981981304930552e-8, 0.000008983055099779535, 0.03278182852591, 40.31678527705744, 0.65659298677277, -4.44255534477492, 0.85341911805263, 0.12923347998204, -0.04625736007561, 4482777.06},
+		{3.09191371068437e-9, 0.000008983055096812155, 0.00006995724062, 23.10934304144901, -0.00023663490511, -0.6321817810242, -0.00663494467273, 0.03430082397953, -0.00466043876332, 2555164.4},
+		{2.890871144776878e-9, 0.000008983055095805407, -3.068298e-8, 7.47137025468032, -0.00000353937994, -0.02145144861037, -0.00001234426596, 0.00010322952773, -0.00000323890364, 826088.5},
+	}
+	ll2mc = [][10]float64{
+		{-0.0015702102444, 111320.7020616939, 1704480524535203, -10338987376042340, 26112667856603880, -35149669176653700, 26595700718403920, -10725012454188240, 1800819912950474, 82.5},
+		{0.0008277824516172526, 111320.7020463578, 647795574.6671607, -4082003173.641316, 10774905663.51142, -15171875531.51559, 12053065338.62167, -5124939663.577472, 913311935.9512032, 67.5},
+		{0.00337398766765, 111320.7020202162, 4481351.045890365, -23393751.19931662, 79682215.47186455, -115964993.2797253, 97236711.15602145, -43661946.33752821, 8477230.501135234, 52.5},
+		{0.00220636496208, 111320.7020209128, 51751.86112841131, 3796837.749470245, 992013.7397791013, -1221952.21711287, 1340652.697009075, -620943.6990984312, 144416.9293806241, 37.5},
+		{-0.0003441963504368392, 111320.7020576856, 278.2353980772752, 2485758.690035394, 6070.750963243378, 54821.18345352118, 9540.606633304236, -2710.55326746645, 1405.483844121726, 22.5},
+		{-0.0003218135878613132, 111320.7020701615, 0.00369383431289, 823725.6402795718, 0.46104986909093, 2351.343141331292, 1.58060784298199, 8.77738589078284, 0.37238884252424, 7.45},
+	}
+)
+
+func mcConvert(x, y float64, k [10]float64) (float64, float64) {
+	outX := k[0] + k[1]*math.Abs(x)
+	c := math.Abs(y) / k[9]
+	outY := k[2] + k[3]*c + k[4]*c*c + k[5]*c*c*c + k[6]*c*c*c*c + k[7]*c*c*c*c*c + k[8]*c*c*c*c*c*c
+	return math.Copysign(outX, x), math.Copysign(outY, y)
+}
+
+// BD09MCToBD09 unprojects a Baidu Mercator point.
+func BD09MCToBD09(c geodist.Coord) geodist.Coord {
+	y := math.Abs(c.Lat)
+	k := mc2ll[len(mc2ll)-1]
+	for i, band := range mcBand {
+		if y >= band {
+			k = mc2ll[i]
+			break
+		}
+	}
+	lon, lat := mcConvert(c.Lon, c.Lat, k)
+	return geodist.Coord{Lat: lat, Lon: lon}
+}
+
+// BD09ToBD09MC projects a BD-09 point to Baidu Mercator. Latitudes are
+// clamped to ±74°, as Baidu does.
+func BD09ToBD09MC(c geodist.Coord) geodist.Coord {
+	lat := math.Max(math.Min(c.Lat, 74), -74)
+	lon := c.Lon
+	for lon > 180 {
+		lon -= 360
+	}
+	for lon < -180 {
+		lon += 360
+	}
+	k := ll2mc[len(ll2mc)-1]
+	for i, band := range llBand {
+		if math.Abs(lat) >= band {
+			k = ll2mc[i]
+			break
+		}
+	}
+	x, y := mcConvert(lon, lat, k)
+	return geodist.Coord{Lat: y, Lon: x}
+}
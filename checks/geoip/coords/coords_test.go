@@ -0,0 +1,100 @@
+package coords
+
+import (
+	"math"
+	"testing"
+
+	"github.com/jftuga/geodist"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertNear(t *testing.T, want, got geodist.Coord, tolerance float64, msgAndArgs ...interface{}) {
+	t.Helper()
+	if math.Abs(want.Lat-got.Lat) > tolerance || math.Abs(want.Lon-got.Lon) > tolerance {
+		assert.Fail(t, "coordinates differ", "want %v, got %v (±%g)", want, got, tolerance)
+		t.Log(msgAndArgs...)
+	}
+}
+
+var tiananmen = geodist.Coord{Lat: 39.915, Lon: 116.404}
+
+// Reference values from github.com/wandergis/coordtransform.
+func TestKnownValues(t *testing.T) {
+	assertNear(t, geodist.Coord{Lat: 39.91640428150164, Lon: 116.41024449916938}, WGS84ToGCJ02(tiananmen), 1e-9)
+	assertNear(t, geodist.Coord{Lat: 39.92133699351021, Lon: 116.41036949371029}, GCJ02ToBD09(tiananmen), 1e-9)
+	assertNear(t, geodist.Coord{Lat: 39.90865673957631, Lon: 116.39762729119315}, BD09ToGCJ02(tiananmen), 1e-9)
+
+	warsaw := geodist.Coord{Lat: 52.2296, Lon: 21.0067}
+	assert.True(t, OutOfChina(warsaw))
+	assert.Equal(t, warsaw, WGS84ToGCJ02(warsaw))
+	assert.Equal(t, warsaw, GCJ02ToWGS84(warsaw))
+
+	mc := BD09ToBD09MC(tiananmen)
+	assert.True(t, IsMercator(mc))
+	assert.False(t, IsMercator(tiananmen))
+	assert.InDelta(t, 12958000, mc.Lon, 1000)
+	assert.InDelta(t, 4826000, mc.Lat, 1000)
+}
+
+func TestRoundTrip(t *testing.T) {
+	systems := []System{WGS84, GCJ02, BD09, BD09MC}
+	points := []geodist.Coord{
+		tiananmen,
+		{Lat: 30.2592, Lon: 120.2194},  // Hangzhou
+		{Lat: 22.5431, Lon: 114.0579},  // Shenzhen
+		{Lat: 45.8038, Lon: 126.5350},  // Harbin
+		{Lat: 43.8256, Lon: 87.6168},   // Ürümqi
+		{Lat: 52.2296, Lon: 21.0067},   // Warsaw, outside China
+		{Lat: -33.8688, Lon: 151.2093}, // Sydney
+	}
+
+	for _, p := range points {
+		for _, from := range systems {
+			src, err := Convert(p, WGS84, from)
+			assert.Nil(t, err)
+			wgs, err := ToWGS84(src, from)
+			assert.Nil(t, err)
+			assertNear(t, p, wgs, 1e-5, p, from)
+
+			for _, to := range systems {
+				dst, err := Convert(src, from, to)
+				assert.Nil(t, err)
+				back, err := Convert(dst, to, from)
+				assert.Nil(t, err)
+
+				// GCJ-02 inverts exactly. Baidu's own BD-09 inverse and its
+				// Mercator polynomial fit are good to about a meter.
+				tolerance := 1e-7
+				if from == BD09 || to == BD09 || to == BD09MC {
+					tolerance = 1e-5
+				}
+				if from == BD09MC {
+					tolerance = 1
+				}
+				assertNear(t, src, back, tolerance, p, from, to)
+			}
+		}
+	}
+
+	_, err := Convert(tiananmen, "osgb36", WGS84)
+	assert.NotNil(t, err)
+	_, err = Convert(tiananmen, WGS84, "osgb36")
+	assert.NotNil(t, err)
+}
+
+// Adjacent bands of Baidu's Mercator polynomials must agree at the latitude
+// separating them, or points near it jump by kilometers.
+func TestMercatorBands(t *testing.T) {
+	for i := 1; i < len(llBand)-1; i++ {
+		lat := llBand[i]
+		_, above := mcConvert(0, lat, ll2mc[i])
+		_, below := mcConvert(0, lat, ll2mc[i+1])
+		assert.InDelta(t, above, below, 20, "ll2mc at %v°", lat)
+	}
+	for i := 1; i < len(mcBand)-1; i++ {
+		y := mcBand[i]
+		_, above := mcConvert(0, y, mc2ll[i])
+		_, below := mcConvert(0, y, mc2ll[i+1])
+		assert.InDelta(t, above, below, 0.001, "mc2ll at %v", y)
+	}
+}
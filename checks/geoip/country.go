@@ -0,0 +1,164 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+)
+
+// Country is an entry of the ISO 3166-1 table.
+type Country struct {
+	Alpha2  string `json:"alpha2"`
+	Alpha3  string `json:"alpha3"`
+	Numeric string `json:"numeric,omitempty"`
+	Name    string `json:"name"`
+	// Aliases are the ISO and official names, when they differ from Name,
+	// native names and common abbreviations.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// CountryError is returned for input that isn't a country, with the
+// country it most looks like, if any.
+type CountryError struct {
+	Input      string
+	Suggestion *Country
+}
+
+func (e *CountryError) Error() string {
+	if e.Suggestion != nil {
+		return fmt.Sprintf("unknown country %q, did you mean %s (%s)?", e.Input, e.Suggestion.Name, e.Suggestion.Alpha2)
+	}
+	return fmt.Sprintf("unknown country %q", e.Input)
+}
+
+// minCountrySuggestion is how alike input must be to a country's name for
+// the country to be suggested.
+const minCountrySuggestion = 0.6
+
+// usStates and caProvinces are the postal codes that follow a city in the
+// addresses of those countries, as in "San Jose, CA".
+var (
+	usStates = map[string]bool{
+		"AL": true, "AK": true, "AZ": true, "AR": true, "CA": true, "CO": true, "CT": true,
+		"DE": true, "DC": true, "FL": true, "GA": true, "HI": true, "ID": true, "IL": true,
+		"IN": true, "IA": true, "KS": true, "KY": true, "LA": true, "ME": true, "MD": true,
+		"MA": true, "MI": true, "MN": true, "MS": true, "MO": true, "MT": true, "NE": true,
+		"NV": true, "NH": true, "NJ": true, "NM": true, "NY": true, "NC": true, "ND": true,
+		"OH": true, "OK": true, "OR": true, "PA": true, "RI": true, "SC": true, "SD": true,
+		"TN": true, "TX": true, "UT": true, "VT": true, "VA": true, "WA": true, "WV": true,
+		"WI": true, "WY": true,
+	}
+	caProvinces = map[string]bool{
+		"AB": true, "BC": true, "MB": true, "NB": true, "NL": true, "NS": true, "NT": true,
+		"NU": true, "ON": true, "PE": true, "QC": true, "SK": true, "YT": true,
+	}
+)
+
+// countries is the table in ISO3166JSON, indexed on first use.
+var countries struct {
+	sync.Once
+	byCode map[string]*Country
+	byName map[string]*Country
+	err    error
+}
+
+func loadCountries() error {
+	countries.Do(func() {
+		var table []Country
+		if err := json.Unmarshal(ISO3166JSON, &table); err != nil {
+			countries.err = fmt.Errorf("parsing ISO 3166 table: %w", err)
+			return
+		}
+		countries.byCode = make(map[string]*Country, 3*len(table))
+		countries.byName = make(map[string]*Country, 4*len(table))
+		for i := range table {
+			c := &table[i]
+			for _, code := range []string{c.Alpha2, c.Alpha3, c.Numeric} {
+				if code != "" {
+					countries.byCode[code] = c
+				}
+			}
+			for _, name := range append([]string{c.Name}, c.Aliases...) {
+				countries.byName[foldName(name)] = c
+			}
+		}
+	})
+	return countries.err
+}
+
+// ResolveCountry finds the country input names: an alpha-2, alpha-3 or
+// numeric ISO 3166-1 code, or a name, in English or natively, regardless of
+// case, diacritics and punctuation. A US or Canadian address such as
+// "San Jose, CA" resolves to its country. Anything else is a
+// KindInvalidInput error wrapping a *CountryError.
+func ResolveCountry(input string) (Country, error) {
+	if err := loadCountries(); err != nil {
+		return Country{}, checks.Errorf(checks.KindInternal, "%w", err)
+	}
+	if c := lookupCountry(input); c != nil {
+		return *c, nil
+	}
+
+	// The region of an address, or the country at its end
+	if i := strings.LastIndexAny(input, ",，"); i >= 0 {
+		_, size := utf8.DecodeRuneInString(input[i:])
+		tail := input[i+size:]
+		region := strings.ToUpper(strings.TrimSpace(tail))
+		switch {
+		case usStates[region]:
+			return *countries.byCode["US"], nil
+		case caProvinces[region]:
+			return *countries.byCode["CA"], nil
+		}
+		if c := lookupCountry(tail); c != nil {
+			return *c, nil
+		}
+	}
+
+	err := &CountryError{Input: input}
+	folded := foldName(input)
+	best := 0.0
+	for name, c := range countries.byName {
+		s := nameSimilarity(folded, name)
+		if s > best || s == best && err.Suggestion != nil && c.Alpha2 < err.Suggestion.Alpha2 {
+			best, err.Suggestion = s, c
+		}
+	}
+	if best < minCountrySuggestion {
+		err.Suggestion = nil
+	}
+	return Country{}, checks.Errorf(checks.KindInvalidInput, "%w", err)
+}
+
+func lookupCountry(input string) *Country {
+	s := strings.Trim(input, " \t\n,，")
+	if s == "" {
+		return nil
+	}
+	code := strings.ToUpper(s)
+	if len(code) < 3 && strings.Trim(code, "0123456789") == "" {
+		code = strings.Repeat("0", 3-len(code)) + code
+	}
+	if c, ok := countries.byCode[code]; ok {
+		return c
+	}
+	return countries.byName[foldName(s)]
+}
+
+// providerCountry turns the country a geocoder or IP geolocation provider
+// returned, sometimes a name rather than a code, into an alpha-2 code. A
+// country that can't be resolved is kept, in upper case.
+func providerCountry(country string) string {
+	if country == "" {
+		return ""
+	}
+	c, err := ResolveCountry(country)
+	if err != nil {
+		return strings.ToUpper(strings.TrimSpace(country))
+	}
+	return c.Alpha2
+}
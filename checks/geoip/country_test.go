@@ -0,0 +1,156 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveCountry(t *testing.T) {
+	cases := map[string]string{
+		"US":                       "US",
+		"us":                       "US",
+		" CN ":                     "CN",
+		"USA":                      "US",
+		"can":                      "CA",
+		"840":                      "US",
+		"76":                       "BR",
+		"United States":            "US",
+		"united states of america": "US",
+		"U.S.A.":                   "US",
+		"Canada":                   "CA",
+		"China":                    "CN",
+		"中国":                       "CN",
+		"Deutschland":              "DE",
+		"España":                   "ES",
+		"Espana":                   "ES",
+		"Côte d'Ivoire":            "CI",
+		"Cote d’Ivoire":            "CI",
+		"Korea, Republic of":       "KR",
+		"South Korea":              "KR",
+		"Viet Nam":                 "VN",
+		"Vietnam":                  "VN",
+		"UK":                       "GB",
+		"Türkiye":                  "TR",
+		"Russian Federation":       "RU",
+		"Kosovo":                   "XK",
+		"San Jose, CA":             "US",
+		"Montreal, QC":             "CA",
+		"Hangzhou, China":          "CN",
+		"Warsaw, Poland":           "PL",
+	}
+	for input, want := range cases {
+		c, err := ResolveCountry(input)
+		if assert.Nil(t, err, input) {
+			assert.Equal(t, want, c.Alpha2, input)
+		}
+	}
+
+	suggestions := map[string]string{
+		"Germny":        "DE",
+		"Untied States": "US",
+		"Chinna":        "CN",
+		"Polnad":        "PL",
+	}
+	for input, want := range suggestions {
+		_, err := ResolveCountry(input)
+		assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err), input)
+		var countryErr *CountryError
+		if assert.True(t, errors.As(err, &countryErr), input) && assert.NotNil(t, countryErr.Suggestion, input) {
+			assert.Equal(t, want, countryErr.Suggestion.Alpha2, input)
+			assert.Contains(t, err.Error(), "did you mean", input)
+		}
+	}
+
+	for _, input := range []string{"", "Atlantis", "ZZ", "999"} {
+		_, err := ResolveCountry(input)
+		assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err), input)
+		var countryErr *CountryError
+		if assert.True(t, errors.As(err, &countryErr), input) {
+			assert.Nil(t, countryErr.Suggestion, input)
+		}
+	}
+}
+
+func TestCountryTable(t *testing.T) {
+	var table []Country
+	assert.Nil(t, json.Unmarshal(ISO3166JSON, &table))
+
+	var continents map[string]string
+	assert.Nil(t, json.Unmarshal(CountryToContinentJSON, &continents))
+	assert.Len(t, table, len(continents))
+
+	// No name or alias is shared by two countries.
+	names := map[string]string{}
+	for _, c := range table {
+		assert.Len(t, c.Alpha2, 2)
+		assert.Len(t, c.Alpha3, 3)
+		assert.Contains(t, continents, c.Alpha2)
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			if other, ok := names[foldName(name)]; ok {
+				assert.Equal(t, other, c.Alpha2, name)
+			}
+			names[foldName(name)] = c.Alpha2
+		}
+	}
+}
+
+func TestProviderCountry(t *testing.T) {
+	assert.Equal(t, "CN", providerCountry("China"))
+	assert.Equal(t, "US", providerCountry("us"))
+	assert.Equal(t, "EU", providerCountry("eu"))
+	assert.Equal(t, "", providerCountry(""))
+
+	// Locations are compared by code, whatever the provider returned.
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+	g, _, err := geodata.filterByMinerID(context.Background(), "f02620", 2055000, 40320)
+	assert.Nil(t, err)
+	var ip string
+	for _, m := range g.MultiaddrsIPs {
+		ip = m.addr().String()
+	}
+	provider := fakeProvider{locations: map[string]IPLocation{
+		ip: {CountryCode: "Poland", City: "Warszawa"},
+	}}
+	match, found, _, err := findMatch(context.Background(), provider, g, MinerData{"f02620", "Warsaw", "PL"}, nil, 100, checks.DefaultMinCitySimilarity)
+	assert.Nil(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, "PL", found[0].CountryCode)
+	}
+	if assert.NotNil(t, match) {
+		assert.Equal(t, CityNormalized, match.CityMatch)
+	}
+}
+
+func TestGeoMatchExistsCountry(t *testing.T) {
+	t.Setenv("MAXMIND_USER_ID", "skip")
+	t.Setenv("IPINFO_TOKEN", "skip")
+
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+
+	for _, country := range []string{"PL", "pol", "616", "Poland", "Polska", "Warsaw, Poland"} {
+		ok, _, err := GeoMatchExists(context.Background(), geodata, nil, policy.Geo, 2055000, MinerData{"f02620", "Warsaw", country})
+		assert.Nil(t, err, country)
+		assert.True(t, ok, country)
+	}
+
+	_, _, err = GeoMatchExists(context.Background(), geodata, nil, policy.Geo, 2055000, MinerData{"f02620", "Warsaw", "Polnad"})
+	assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err))
+	assert.EqualError(t, err, `unknown country "Polnad", did you mean Poland (PL)?`)
+}
@@ -3,31 +3,37 @@ package geoip
 import (
 	"context"
 	"log"
-	"net/http"
+	"os"
+	"strconv"
 
-	jsonrpc "github.com/filecoin-project/go-jsonrpc"
-	lotusapi "github.com/filecoin-project/lotus/api"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
 )
 
 // GetCurrentEpoch gets the current chain height from the Lotus API
-func GetCurrentEpoch(ctx context.Context) (int64, error) {
-	headers := http.Header{}
-	api_addr := "api.chain.love"
-
-	var api lotusapi.FullNodeStruct
-	closer, err := jsonrpc.NewMergeClient(ctx,
-		"wss://"+api_addr+"/rpc/v0", "Filecoin",
-		[]interface{}{&api.Internal, &api.CommonStruct.Internal}, headers)
-	if err != nil {
-		log.Fatalf("connecting with lotus failed: %s", err)
+func GetCurrentEpoch(ctx context.Context, api chain.API) (int64, error) {
+	if api == nil {
+		return 0, checks.Errorf(checks.KindInternal, "no lotus client configured")
 	}
-	defer closer()
 
 	ts, err := api.ChainHead(ctx)
 	if err != nil {
-		return 0, err
+		return 0, checks.Errorf(checks.KindUpstreamUnavailable, "getting chain head: %w", err)
 	}
 	height := int64(ts.Height())
 	log.Printf("Chain height: %v\n", height)
 	return height, nil
 }
+
+// getCurrentEpoch prefers, in order, the EPOCH override, the epoch clock
+// and finally the chain head. The clock keeps the freshness window working
+// when the node can't be reached.
+func getCurrentEpoch(ctx context.Context, state *checks.State) (int64, error) {
+	if epoch, err := strconv.ParseInt(os.Getenv("EPOCH"), 10, 64); err == nil && epoch != 0 {
+		return epoch, nil
+	}
+	if state.Clock != nil {
+		return int64(state.Clock.CurrentEpoch()), nil
+	}
+	return GetCurrentEpoch(ctx, state.Lotus)
+}
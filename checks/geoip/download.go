@@ -0,0 +1,213 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultMaxFeedBytes caps the size of a feed download.
+const DefaultMaxFeedBytes = 1 << 30
+
+// Downloader fetches feeds over HTTP. Transient failures are retried with
+// exponential backoff, and a body is only handed back once it arrived whole
+// and passed validation.
+type Downloader struct {
+	Client *http.Client
+	// Attempts is how many times a download is tried in all.
+	Attempts int
+	// Backoff is the wait after the first failed attempt, doubled after
+	// each one that follows.
+	Backoff time.Duration
+	// MaxBytes rejects feeds larger than this.
+	MaxBytes int64
+	// TempDir holds downloads until they're validated; os.TempDir() when
+	// empty.
+	TempDir string
+}
+
+// NewDownloader returns a Downloader with the defaults used in production.
+func NewDownloader() *Downloader {
+	return &Downloader{
+		Client:   &http.Client{Timeout: 5 * time.Minute},
+		Attempts: 3,
+		Backoff:  time.Second,
+		MaxBytes: DefaultMaxFeedBytes,
+	}
+}
+
+// permanentError is a failure retrying won't fix.
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Unwrap() error {
+	return e.error
+}
+
+// Download sends req until it succeeds or runs out of attempts. On a 200
+// it returns the body in a temporary file, which the caller must close and
+// remove; on a 304 the file is nil. A body validate rejects is retried like
+// a truncated one. validate is validateJSON when nil: a caller that parses
+// the body anyway can validate it that way instead, and read it only once.
+func (d *Downloader) Download(ctx context.Context, req *http.Request, validate func(io.Reader) error) (*http.Response, *os.File, error) {
+	if validate == nil {
+		validate = validateJSON
+	}
+	attempts := d.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	backoff := d.Backoff
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		var resp *http.Response
+		var f *os.File
+		resp, f, err = d.try(req.Clone(ctx), validate)
+		if err == nil {
+			return resp, f, nil
+		}
+		var permanent permanentError
+		if errors.As(err, &permanent) || attempt >= attempts {
+			break
+		}
+
+		log.Printf("Downloading %s failed (attempt %d of %d), retrying in %s: %v\n",
+			req.URL, attempt, attempts, backoff, err)
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return nil, nil, err
+}
+
+func (d *Downloader) try(req *http.Request, validate func(io.Reader) error) (*http.Response, *os.File, error) {
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK:
+	case resp.StatusCode == http.StatusNotModified:
+		return resp, nil, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, nil, fmt.Errorf("unexpected status %s", resp.Status)
+	default:
+		return nil, nil, permanentError{fmt.Errorf("unexpected status %s", resp.Status)}
+	}
+
+	maxBytes := d.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxFeedBytes
+	}
+	if resp.ContentLength > maxBytes {
+		return nil, nil, permanentError{fmt.Errorf("feed is %d bytes, more than the %d allowed", resp.ContentLength, maxBytes)}
+	}
+
+	if d.TempDir != "" {
+		if err := os.MkdirAll(d.TempDir, 0755); err != nil {
+			return nil, nil, permanentError{err}
+		}
+	}
+	f, err := os.CreateTemp(d.TempDir, "feed-*.json")
+	if err != nil {
+		return nil, nil, permanentError{err}
+	}
+	ok := false
+	defer func() {
+		if !ok {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+
+	n, err := io.Copy(f, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading body: %w", err)
+	}
+	switch {
+	case n > maxBytes:
+		return nil, nil, permanentError{fmt.Errorf("feed is more than the %d bytes allowed", maxBytes)}
+	case resp.ContentLength >= 0 && n != resp.ContentLength:
+		return nil, nil, fmt.Errorf("truncated body: got %d of %d bytes", n, resp.ContentLength)
+	case n == 0:
+		return nil, nil, fmt.Errorf("empty body")
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, permanentError{err}
+	}
+	if err := validate(f); err != nil {
+		return nil, nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, permanentError{err}
+	}
+
+	ok = true
+	return resp, f, nil
+}
+
+// validateJSON checks r holds exactly one JSON value, without reading it
+// all into memory.
+func validateJSON(r io.Reader) error {
+	dec := json.NewDecoder(r)
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF && depth == 0 {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid JSON: %w", err)
+		}
+		switch tok {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+		if depth == 0 && dec.More() {
+			return fmt.Errorf("invalid JSON: data after the top-level value")
+		}
+	}
+}
+
+// writeFileAtomic writes r to path through a temporary file in the same
+// directory, so readers never see a partial file.
+func writeFileAtomic(path string, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,236 @@
+package geoip
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyServer answers each request with the next of its responses, and
+// repeats the last one after that.
+type flakyServer struct {
+	*httptest.Server
+	responses []func(w http.ResponseWriter)
+	requests  int
+}
+
+func newFlakyServer(t *testing.T, responses ...func(w http.ResponseWriter)) *flakyServer {
+	s := &flakyServer{responses: responses}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := s.requests
+		if i >= len(s.responses) {
+			i = len(s.responses) - 1
+		}
+		s.requests++
+		s.responses[i](w)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func status(code int) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+func body(s string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		io.WriteString(w, s)
+	}
+}
+
+// truncated claims a longer body than it sends.
+func truncated(s string) func(w http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set("Content-Length", strconv.Itoa(len(s)+100))
+		io.WriteString(w, s)
+	}
+}
+
+func testDownloader(t *testing.T) *Downloader {
+	d := NewDownloader()
+	d.Backoff = time.Millisecond
+	d.TempDir = t.TempDir()
+	return d
+}
+
+func download(t *testing.T, d *Downloader, url string) (string, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	assert.Nil(t, err)
+	_, f, err := d.Download(context.Background(), req, nil)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	b, err := io.ReadAll(f)
+	assert.Nil(t, err)
+	return string(b), nil
+}
+
+func TestDownloader(t *testing.T) {
+	cases := []struct {
+		name      string
+		responses []func(w http.ResponseWriter)
+		want      string
+		requests  int
+	}{
+		{"ok", []func(http.ResponseWriter){body(`{"date": null}`)}, `{"date": null}`, 1},
+		{"retries 5xx", []func(http.ResponseWriter){status(500), status(502), body(`[]`)}, `[]`, 3},
+		{"retries 429", []func(http.ResponseWriter){status(429), body(`[]`)}, `[]`, 2},
+		{"retries truncated", []func(http.ResponseWriter){truncated(`{"date": `), body(`{}`)}, `{}`, 2},
+		{"retries invalid JSON", []func(http.ResponseWriter){body(`{"date": `), body(`{}`)}, `{}`, 2},
+		{"gives up", []func(http.ResponseWriter){status(503)}, "", 3},
+		{"always truncated", []func(http.ResponseWriter){truncated(`{}`)}, "", 3},
+		{"empty", []func(http.ResponseWriter){body(``)}, "", 3},
+		{"trailing data", []func(http.ResponseWriter){body(`{} {}`)}, "", 3},
+		{"no retry on 404", []func(http.ResponseWriter){status(404), body(`{}`)}, "", 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := newFlakyServer(t, tc.responses...)
+			d := testDownloader(t)
+			got, err := download(t, d, srv.URL)
+			if tc.want == "" {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+				assert.Equal(t, tc.want, got)
+			}
+			assert.Equal(t, tc.requests, srv.requests)
+
+			// Nothing is left behind, whether the download was kept or not
+			left, err := os.ReadDir(d.TempDir)
+			assert.Nil(t, err)
+			assert.Empty(t, left)
+		})
+	}
+}
+
+func TestDownloaderMaxBytes(t *testing.T) {
+	srv := newFlakyServer(t, body(`["`+strings.Repeat("x", 100)+`"]`))
+	d := testDownloader(t)
+	d.MaxBytes = 50
+	_, err := download(t, d, srv.URL)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, srv.requests)
+}
+
+// The temp dir, e.g. the cache dir of a fresh container, is created.
+func TestDownloaderMissingTempDir(t *testing.T) {
+	srv := newFlakyServer(t, body(`{"version": 1}`))
+	d := testDownloader(t)
+	d.TempDir = filepath.Join(t.TempDir(), "kyc-feeds")
+	data, err := download(t, d, srv.URL)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"version": 1}`, data)
+}
+
+// A download that fails validation never replaces the stored copy.
+func TestFeedCacheKeepsStoredCopy(t *testing.T) {
+	srv := newFlakyServer(t, body(`{"version": 1}`), truncated(`{"version": `), body(`not json`))
+	store := DirStore{Dir: t.TempDir()}
+	now := time.Date(2022, 8, 8, 11, 0, 0, 0, time.UTC)
+	cache := NewFeedCache(store)
+	cache.Now = func() time.Time { return now }
+	cache.Downloader = testDownloader(t)
+	ctx := context.Background()
+	url := srv.URL + "/feed.json"
+
+	_, first, err := cache.refresh(ctx, url, time.Minute, validateJSON)
+	assert.Nil(t, err)
+
+	now = now.Add(time.Hour)
+	_, feed, err := cache.refresh(ctx, url, time.Minute, validateJSON)
+	assert.Nil(t, err)
+	assert.Equal(t, first.Updated, feed.Updated)
+	assert.Equal(t, 4, srv.requests)
+
+	r, _, err := store.Open(ctx, "feed.json")
+	assert.Nil(t, err)
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"version": 1}`, string(b))
+
+	entries, err := os.ReadDir(store.Dir)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2, "only the feed and its metadata")
+
+	// With no stored copy the failure is reported
+	cold := NewFeedCache(DirStore{Dir: t.TempDir()})
+	cold.Downloader = cache.Downloader
+	_, _, err = cold.refresh(ctx, url, time.Minute, validateJSON)
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+}
+
+// A download that's valid JSON, but not a feed that can be parsed, is
+// neither stored nor trusted for max age.
+func TestFeedCacheRejectsUnparseableFeed(t *testing.T) {
+	srv := newFlakyServer(t, body(`{"version": 1}`), body(`{"version": 2}`))
+	store := DirStore{Dir: t.TempDir()}
+	now := time.Date(2022, 8, 8, 11, 0, 0, 0, time.UTC)
+	cache := NewFeedCache(store)
+	cache.Now = func() time.Time { return now }
+	cache.Downloader = testDownloader(t)
+	ctx := context.Background()
+	url := srv.URL + "/feed.json"
+	parse := func(r io.Reader) error {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(string(b), "2") {
+			return errors.New("unsupported version")
+		}
+		return nil
+	}
+	stored := func() string {
+		r, _, err := store.Open(ctx, "feed.json")
+		if err != nil {
+			return err.Error()
+		}
+		defer r.Close()
+		b, _ := io.ReadAll(r)
+		return string(b)
+	}
+
+	_, first, err := cache.refresh(ctx, url, time.Minute, parse)
+	assert.Nil(t, err)
+
+	// Retried like a truncated download, then the stored copy is kept, and
+	// checked again on the next invocation
+	now = now.Add(time.Hour)
+	_, feed, err := cache.refresh(ctx, url, time.Minute, parse)
+	assert.Nil(t, err)
+	assert.Equal(t, first.Updated, feed.Updated)
+	assert.Equal(t, `{"version": 1}`, stored())
+	assert.Equal(t, 4, srv.requests)
+
+	_, feed, err = cache.refresh(ctx, url, time.Minute, parse)
+	assert.Nil(t, err)
+	assert.Equal(t, first.Updated, feed.Updated)
+	assert.Equal(t, `{"version": 1}`, stored())
+	assert.Equal(t, 7, srv.requests)
+
+	// With no stored copy the failure is reported, and nothing stored
+	bad := newFlakyServer(t, body(`{"version": 2}`))
+	cold := NewFeedCache(DirStore{Dir: t.TempDir()})
+	cold.Downloader = cache.Downloader
+	_, _, err = cold.refresh(ctx, bad.URL+"/feed.json", time.Minute, parse)
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+	_, err = cold.Store.Stat(ctx, "feed.json")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
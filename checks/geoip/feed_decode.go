@@ -0,0 +1,96 @@
+package geoip
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeReport streams a provider.quest report from r, so a feed is never
+// held in memory as both JSON and records. It returns the report's date,
+// and passes each of its records to record as the decoder reaches it. The
+// records are an object keyed by IP when keyed is true, and an array, with
+// an empty key, otherwise. found is false when the report has no records
+// at all.
+func decodeReport(r io.Reader, feed, recordsKey string, keyed bool, record func(key string, dec *json.Decoder) error) (date *string, found bool, err error) {
+	dec := json.NewDecoder(r)
+	fail := func(err error) (*string, bool, error) {
+		return nil, false, fmt.Errorf("parsing %s feed: %w", feed, err)
+	}
+
+	if err := expectDelim(dec, '{'); err != nil {
+		return fail(err)
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return fail(err)
+		}
+		key, _ := tok.(string)
+		switch {
+		case key == "date":
+			if err := dec.Decode(&date); err != nil {
+				return fail(fmt.Errorf("date: %w", err))
+			}
+		case strings.EqualFold(key, recordsKey):
+			found, err = decodeRecords(dec, keyed, record)
+			if err != nil {
+				return fail(fmt.Errorf("%s: %w", recordsKey, err))
+			}
+		default:
+			var skip json.RawMessage
+			if err := dec.Decode(&skip); err != nil {
+				return fail(err)
+			}
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return fail(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return fail(fmt.Errorf("data after the report"))
+	}
+	return date, found, nil
+}
+
+func decodeRecords(dec *json.Decoder, keyed bool, record func(key string, dec *json.Decoder) error) (bool, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return false, err
+	}
+	switch {
+	case tok == nil:
+		return false, nil
+	case tok == json.Delim('[') && !keyed:
+		for dec.More() {
+			if err := record("", dec); err != nil {
+				return false, err
+			}
+		}
+		return true, expectDelim(dec, ']')
+	case tok == json.Delim('{') && keyed:
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return false, err
+			}
+			if err := record(tok.(string), dec); err != nil {
+				return false, fmt.Errorf("%s: %w", tok, err)
+			}
+		}
+		return true, expectDelim(dec, '}')
+	}
+	return false, fmt.Errorf("unexpected %v", tok)
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, found %v", delim, tok)
+	}
+	return nil
+}
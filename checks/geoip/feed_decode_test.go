@@ -0,0 +1,116 @@
+package geoip
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// syntheticMultiaddrsIPs streams a multiaddrs-ips feed of n records, spread
+// over n/20 miners with two records per IP.
+func syntheticMultiaddrsIPs(n int) io.Reader {
+	r, w := io.Pipe()
+	go func() {
+		bw := bufio.NewWriter(w)
+		fmt.Fprint(bw, `{"date": "2022-08-07T22:40:11.103Z", "multiaddrsIps": [`)
+		for i := 0; i < n; i++ {
+			if i > 0 {
+				bw.WriteByte(',')
+			}
+			ip := fmt.Sprintf("45.%d.%d.%d", i/2/65536%256, i/2/256%256, i/2%256)
+			fmt.Fprintf(bw, `{"miner": "f0%d", "maddr": "/ip4/%s/tcp/%d", "peerId": "12D3KooWBNRq3xPLBoHHKqvtKwLkUQDsPWR8EVZMHuEL%06d", "ip": %q, "epoch": %d, "timestamp": "2022-08-06T18:20:00.000Z", "dht": true, "chain": false}`,
+				1000+i%(n/20+1), ip, 24000+i%2, i, ip, 2050000+i%1000)
+		}
+		fmt.Fprint(bw, `]}`)
+		w.CloseWithError(bw.Flush())
+	}()
+	return r
+}
+
+func TestGeoDataIndex(t *testing.T) {
+	report, err := ReadMultiAddrsIPs(syntheticMultiaddrsIPs(1000))
+	assert.Nil(t, err)
+	assert.Len(t, report.MultiaddrsIPs, 1000)
+
+	g := &GeoData{MultiaddrsIPs: report.MultiaddrsIPs}
+	records := g.MinerRecords("f01000")
+	assert.Len(t, records, 20)
+	for _, r := range records {
+		assert.Equal(t, "f01000", r.Miner)
+	}
+	assert.Empty(t, g.MinerRecords("f09999999"))
+
+	records = g.IPRecords("45.0.0.1")
+	assert.Len(t, records, 2)
+	assert.Equal(t, "/ip4/45.0.0.1/tcp/24000", records[0].Maddr)
+	assert.Equal(t, "/ip4/45.0.0.1/tcp/24001", records[1].Maddr)
+	assert.Empty(t, g.IPRecords("192.0.2.1"))
+
+	filtered, _, err := g.filterByMinerID(context.Background(), "f01000", 2051000, 1000)
+	assert.Nil(t, err)
+	assert.Len(t, filtered.MultiaddrsIPs, 20)
+	for _, r := range filtered.MultiaddrsIPs {
+		assert.Equal(t, "f01000", r.Miner)
+	}
+}
+
+func BenchmarkReadMultiAddrsIPs(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		report, err := ReadMultiAddrsIPs(syntheticMultiaddrsIPs(1_000_000))
+		if err != nil {
+			b.Fatal(err)
+		}
+		g := &GeoData{MultiaddrsIPs: report.MultiaddrsIPs}
+		g.index()
+	}
+}
+
+func benchmarkGeoData(b *testing.B) *GeoData {
+	b.Helper()
+	report, err := ReadMultiAddrsIPs(syntheticMultiaddrsIPs(1_000_000))
+	if err != nil {
+		b.Fatal(err)
+	}
+	g := &GeoData{MultiaddrsIPs: report.MultiaddrsIPs}
+	g.index()
+	return g
+}
+
+func BenchmarkMinerRecords(b *testing.B) {
+	g := benchmarkGeoData(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(g.MinerRecords(fmt.Sprintf("f0%d", 1000+i%50000))) == 0 {
+			b.Fatal("no records")
+		}
+	}
+}
+
+func BenchmarkIPRecords(b *testing.B) {
+	g := benchmarkGeoData(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if len(g.IPRecords(fmt.Sprintf("45.0.%d.%d", i/256%256, i%256))) == 0 {
+			b.Fatal("no records")
+		}
+	}
+}
+
+func BenchmarkFilterByMinerID(b *testing.B) {
+	g := benchmarkGeoData(b)
+	ctx := context.Background()
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := g.filterByMinerID(ctx, fmt.Sprintf("f0%d", 1000+i%50000), 2051000, 40320); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
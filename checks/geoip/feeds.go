@@ -0,0 +1,352 @@
+package geoip
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+)
+
+// Feed describes the stored copy of a provider.quest feed.
+type Feed struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	// Updated is when the stored copy was downloaded.
+	Updated time.Time `json:"updated"`
+	// Checked is when the feed was last confirmed to be current.
+	Checked time.Time `json:"checked"`
+}
+
+// FeedStore keeps downloaded feeds. A store shared by every container lets
+// a cold start skip the download. DirStore is one when its directory is on
+// a file system every container mounts, such as EFS, and otherwise keeps
+// the feeds for the container's later invocations only.
+type FeedStore interface {
+	// Open returns the stored copy of the feed called name. The error wraps
+	// fs.ErrNotExist when there is none.
+	Open(ctx context.Context, name string) (io.ReadCloser, Feed, error)
+	// Stat returns what's known about the stored copy without reading it.
+	Stat(ctx context.Context, name string) (Feed, error)
+	// Put stores a new copy of the feed.
+	Put(ctx context.Context, name string, body io.Reader, feed Feed) error
+	// Touch replaces what's known about the stored copy, such as when it
+	// was last checked, leaving the copy itself alone.
+	Touch(ctx context.Context, name string, feed Feed) error
+}
+
+// DirStore keeps each feed in a directory, next to a .meta.json file
+// holding its Feed.
+type DirStore struct {
+	Dir string
+}
+
+func (s DirStore) paths(name string) (string, string) {
+	p := filepath.Join(s.Dir, name)
+	return p, p + ".meta.json"
+}
+
+func (s DirStore) Stat(_ context.Context, name string) (Feed, error) {
+	_, metaPath := s.paths(name)
+	var feed Feed
+	meta, err := os.ReadFile(metaPath)
+	if err != nil {
+		return feed, err
+	}
+	if err := json.Unmarshal(meta, &feed); err != nil {
+		return feed, fmt.Errorf("reading %s: %w", metaPath, err)
+	}
+	return feed, nil
+}
+
+func (s DirStore) Open(ctx context.Context, name string) (io.ReadCloser, Feed, error) {
+	feed, err := s.Stat(ctx, name)
+	if err != nil {
+		return nil, feed, err
+	}
+	bodyPath, _ := s.paths(name)
+	f, err := os.Open(bodyPath)
+	if err != nil {
+		return nil, feed, err
+	}
+	return f, feed, nil
+}
+
+func (s DirStore) Put(ctx context.Context, name string, body io.Reader, feed Feed) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return err
+	}
+	bodyPath, _ := s.paths(name)
+	if err := writeFileAtomic(bodyPath, body); err != nil {
+		return err
+	}
+	return s.Touch(ctx, name, feed)
+}
+
+func (s DirStore) Touch(_ context.Context, name string, feed Feed) error {
+	_, metaPath := s.paths(name)
+	meta, err := json.Marshal(feed)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(metaPath, bytes.NewReader(meta))
+}
+
+// FeedCache downloads feeds into a FeedStore with conditional GETs, and
+// keeps the GeoData parsed from them in memory for as long as they don't
+// change.
+type FeedCache struct {
+	Store      FeedStore
+	Downloader *Downloader
+	Now        func() time.Time
+
+	mu      sync.Mutex
+	feeds   map[string]Feed
+	geodata *GeoData
+	loaded  [3]time.Time
+}
+
+// NewFeedCache returns a cache over store.
+func NewFeedCache(store FeedStore) *FeedCache {
+	return &FeedCache{
+		Store:      store,
+		Downloader: NewDownloader(),
+		Now:        time.Now,
+		feeds:      make(map[string]Feed),
+	}
+}
+
+// feedName is the name a feed is stored under: the last element of its
+// URL path.
+func feedName(feedURL string) (string, error) {
+	u, err := url.Parse(feedURL)
+	if err != nil {
+		return "", checks.Errorf(checks.KindInternal, "invalid feed URL %q: %w", feedURL, err)
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "", checks.Errorf(checks.KindInternal, "invalid feed URL %q", feedURL)
+	}
+	return name, nil
+}
+
+// refresh makes sure the store holds a current copy of the feed at
+// feedURL, downloading it only if it changed. A download is parsed as it
+// arrives, and stored only if parse accepts it, so that a feed that can't be
+// used isn't kept for max age.
+func (c *FeedCache) refresh(ctx context.Context, feedURL string, maxAge time.Duration, parse func(io.Reader) error) (string, Feed, error) {
+	name, err := feedName(feedURL)
+	if err != nil {
+		return "", Feed{}, err
+	}
+
+	feed, ok := c.feeds[name]
+	if !ok || feed.URL != feedURL {
+		// Cold start: pick up what an earlier container stored, if anything
+		feed, err = c.Store.Stat(ctx, name)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Ignoring stored copy of %s: %v\n", name, err)
+		}
+		if err != nil || feed.URL != feedURL {
+			feed = Feed{URL: feedURL}
+		}
+	}
+
+	now := c.Now()
+	if !feed.Updated.IsZero() && now.Sub(feed.Checked) < maxAge {
+		c.feeds[name] = feed
+		return name, feed, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return "", feed, checks.Errorf(checks.KindInternal, "downloading %s: %w", name, err)
+	}
+	if !feed.Updated.IsZero() {
+		if feed.ETag != "" {
+			req.Header.Set("If-None-Match", feed.ETag)
+		}
+		if feed.LastModified != "" {
+			req.Header.Set("If-Modified-Since", feed.LastModified)
+		}
+	}
+
+	resp, body, err := c.Downloader.Download(ctx, req, parse)
+	if err != nil {
+		return c.fallback(name, feed, err)
+	}
+
+	if body == nil {
+		if feed.Updated.IsZero() {
+			return c.fallback(name, feed, fmt.Errorf("unexpected status %s", resp.Status))
+		}
+		log.Printf("%s not modified since %s\n", name, feed.Updated.Format(time.RFC3339))
+		// So that a cold start doesn't check it again within max age
+		feed.Checked = now
+		if err := c.Store.Touch(ctx, name, feed); err != nil {
+			log.Printf("Unable to record that %s was checked: %v\n", name, err)
+		}
+	} else {
+		defer os.Remove(body.Name())
+		defer body.Close()
+		log.Printf("Downloaded %s\n", name)
+		feed.ETag = resp.Header.Get("ETag")
+		feed.LastModified = resp.Header.Get("Last-Modified")
+		feed.Updated = now
+		feed.Checked = now
+		if err := c.Store.Put(ctx, name, body, feed); err != nil {
+			return "", feed, checks.Errorf(checks.KindUpstreamUnavailable, "storing %s: %w", name, err)
+		}
+	}
+
+	feed.Checked = now
+	c.feeds[name] = feed
+	return name, feed, nil
+}
+
+// fallback keeps using the stored copy of a feed when it can't be checked.
+func (c *FeedCache) fallback(name string, feed Feed, err error) (string, Feed, error) {
+	if feed.Updated.IsZero() {
+		return "", feed, checks.Errorf(checks.KindUpstreamUnavailable, "downloading %s: %w", name, err)
+	}
+	log.Printf("Unable to check %s for updates, using copy from %s: %v\n",
+		name, feed.Updated.Format(time.RFC3339), err)
+	c.feeds[name] = feed
+	return name, feed, nil
+}
+
+// LoadGeoData returns the GeoData parsed from the current feeds, reusing the
+// copy parsed by an earlier invocation when none of them changed.
+func (c *FeedCache) LoadGeoData(ctx context.Context, feeds checks.FeedPolicy) (*GeoData, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Each feed is parsed once: as it's downloaded, or else from the store
+	var multiaddrsIPs *MultiaddrsIPsReport
+	var ipsGeolite2 *IPsGeolite2Report
+	var ipsBaidu *IPsBaiduReport
+	urls := [3]string{feeds.MultiaddrsIPs, feeds.IPsGeolite2, feeds.IPsBaidu}
+	parsers := [3]func(io.Reader) error{
+		func(r io.Reader) (err error) { multiaddrsIPs, err = ReadMultiAddrsIPs(r); return err },
+		func(r io.Reader) (err error) { ipsGeolite2, err = ReadIPsGeolite2(r); return err },
+		func(r io.Reader) (err error) { ipsBaidu, err = ReadIPsBaidu(r); return err },
+	}
+	var names [3]string
+	var updated [3]time.Time
+	for i, feedURL := range urls {
+		name, feed, err := c.refresh(ctx, feedURL, feeds.MaxAgeDuration(), parsers[i])
+		if err != nil {
+			return nil, err
+		}
+		names[i], updated[i] = name, feed.Updated
+	}
+
+	if c.geodata != nil && updated == c.loaded {
+		return c.geodata, nil
+	}
+
+	parsed := [3]bool{multiaddrsIPs != nil, ipsGeolite2 != nil, ipsBaidu != nil}
+	for i, name := range names {
+		if parsed[i] {
+			continue
+		}
+		r, _, err := c.Store.Open(ctx, name)
+		if err != nil {
+			return nil, checks.Errorf(checks.KindUpstreamUnavailable, "opening %s: %w", name, err)
+		}
+		err = parsers[i](r)
+		r.Close()
+		if err != nil {
+			return nil, checks.Errorf(checks.KindUpstreamUnavailable, "parsing %s: %w", name, err)
+		}
+	}
+	geodata, err := newGeoData(multiaddrsIPs, ipsGeolite2, ipsBaidu)
+	if err != nil {
+		return nil, err
+	}
+
+	c.geodata, c.loaded = geodata, updated
+	return geodata, nil
+}
+
+// feedStores open the FeedStore a FEED_STORE URL names, by its scheme.
+var feedStores = map[string]func(u *url.URL) (FeedStore, error){
+	"file": func(u *url.URL) (FeedStore, error) {
+		if u.Path == "" {
+			return nil, fmt.Errorf("no directory in %q", u)
+		}
+		return DirStore{Dir: u.Path}, nil
+	},
+}
+
+// OpenFeedStore opens the FeedStore at location: a directory, or a URL with
+// a scheme in feedStores, such as file:///mnt/efs/kyc-feeds.
+func OpenFeedStore(location string) (FeedStore, error) {
+	u, err := url.Parse(location)
+	if err != nil || u.Scheme == "" {
+		return DirStore{Dir: location}, nil
+	}
+	open, ok := feedStores[u.Scheme]
+	if !ok {
+		return nil, checks.Errorf(checks.KindInternal, "unsupported feed store %q", location)
+	}
+	store, err := open(u)
+	if err != nil {
+		return nil, checks.Errorf(checks.KindInternal, "feed store %q: %w", location, err)
+	}
+	return store, nil
+}
+
+// sharedFeeds is created on first use and then shared by every invocation
+// this container handles.
+var sharedFeeds struct {
+	sync.Once
+	cache *FeedCache
+	err   error
+}
+
+// feedCache returns the container's FeedCache. Feeds are downloaded to
+// FEED_CACHE_DIR, or the same directory under /tmp on every invocation, and
+// stored in FEED_STORE, which defaults to the download directory.
+func feedCache() (*FeedCache, error) {
+	sharedFeeds.Do(func() {
+		dir := os.Getenv("FEED_CACHE_DIR")
+		if dir == "" {
+			dir = filepath.Join(os.TempDir(), "kyc-feeds")
+		}
+		location := os.Getenv("FEED_STORE")
+		if location == "" {
+			location = dir
+		}
+		store, err := OpenFeedStore(location)
+		if err != nil {
+			sharedFeeds.err = err
+			return
+		}
+		sharedFeeds.cache = NewFeedCache(store)
+		sharedFeeds.cache.Downloader.TempDir = dir
+	})
+	return sharedFeeds.cache, sharedFeeds.err
+}
+
+// LoadGeoData loads the feeds through the container's FeedCache.
+func LoadGeoData(ctx context.Context, feeds checks.FeedPolicy) (*GeoData, error) {
+	cache, err := feedCache()
+	if err != nil {
+		return nil, err
+	}
+	return cache.LoadGeoData(ctx, feeds)
+}
@@ -0,0 +1,233 @@
+package geoip
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+// feedServer serves the testdata feeds with an ETag, and counts the
+// requests and full downloads it gets.
+type feedServer struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	version   int
+	requests  int
+	downloads int
+	down      bool
+}
+
+func newFeedServer(t *testing.T) *feedServer {
+	s := &feedServer{version: 1}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.requests++
+		if s.down {
+			http.Error(w, "maintenance", http.StatusServiceUnavailable)
+			return
+		}
+		etag := fmt.Sprintf(`"v%d"`, s.version)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		s.downloads++
+		http.ServeFile(w, r, path.Join("testdata", path.Base(r.URL.Path)))
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *feedServer) policy(maxAge string) checks.FeedPolicy {
+	p, err := checks.ParsePolicy([]byte(fmt.Sprintf(`{
+		"version": "test",
+		"min_power": "0",
+		"geo": {"max_distance_km": 1, "ip_max_age_epochs": 1},
+		"feeds": {
+			"multiaddrs_ips": "%[1]s/multiaddrs-ips-latest.json",
+			"ips_geolite2": "%[1]s/ips-geolite2-latest.json",
+			"ips_baidu": "%[1]s/ips-baidu-latest.json",
+			"max_age": %[2]q
+		}
+	}`, s.URL, maxAge)))
+	if err != nil {
+		panic(err)
+	}
+	return p.Feeds
+}
+
+func (s *feedServer) counts() (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests, s.downloads
+}
+
+// countingStore counts the stored copies read back.
+type countingStore struct {
+	DirStore
+	opens int
+}
+
+func (s *countingStore) Open(ctx context.Context, name string) (io.ReadCloser, Feed, error) {
+	s.opens++
+	return s.DirStore.Open(ctx, name)
+}
+
+func TestFeedCache(t *testing.T) {
+	srv := newFeedServer(t)
+	store := &countingStore{DirStore: DirStore{Dir: t.TempDir()}}
+	now := time.Date(2022, 8, 8, 11, 0, 0, 0, time.UTC)
+	cache := NewFeedCache(store)
+	cache.Now = func() time.Time { return now }
+	cache.Downloader.Backoff = time.Millisecond
+	ctx := context.Background()
+	feeds := srv.policy("10m")
+
+	first, err := cache.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, first.MultiaddrsIPs)
+	assert.NotEmpty(t, first.IPsGeolite2)
+	assert.NotEmpty(t, first.IPsBaidu)
+	requests, downloads := srv.counts()
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, 3, downloads)
+	// Parsed as downloaded, not read back
+	assert.Equal(t, 0, store.opens)
+
+	// Within max age: no requests, and the parsed copy is reused
+	now = now.Add(5 * time.Minute)
+	again, err := cache.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	assert.Same(t, first, again)
+	requests, _ = srv.counts()
+	assert.Equal(t, 3, requests)
+
+	// Past max age: conditional GETs, nothing downloaded or parsed again
+	now = now.Add(10 * time.Minute)
+	again, err = cache.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	assert.Same(t, first, again)
+	requests, downloads = srv.counts()
+	assert.Equal(t, 6, requests)
+	assert.Equal(t, 3, downloads)
+
+	// The check is stored, so a cold start within max age doesn't repeat it
+	stored, err := store.Stat(ctx, "multiaddrs-ips-latest.json")
+	assert.Nil(t, err)
+	assert.Equal(t, now, stored.Checked)
+	warm := NewFeedCache(store)
+	warm.Now = cache.Now
+	_, err = warm.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	requests, _ = srv.counts()
+	assert.Equal(t, 6, requests)
+	assert.Equal(t, 3, store.opens)
+
+	// A new version is downloaded and parsed
+	srv.mu.Lock()
+	srv.version++
+	srv.mu.Unlock()
+	now = now.Add(time.Hour)
+	updated, err := cache.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	assert.NotSame(t, first, updated)
+	_, downloads = srv.counts()
+	assert.Equal(t, 6, downloads)
+
+	// Upstream down: the stored copy is still used
+	srv.mu.Lock()
+	srv.down = true
+	srv.mu.Unlock()
+	now = now.Add(time.Hour)
+	again, err = cache.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	assert.Same(t, updated, again)
+
+	// A cold start finds the feeds in the store
+	srv.mu.Lock()
+	srv.down = false
+	srv.mu.Unlock()
+	cold := NewFeedCache(store)
+	cold.Now = cache.Now
+	geodata, err := cold.LoadGeoData(ctx, feeds)
+	assert.Nil(t, err)
+	assert.Equal(t, updated.MultiaddrsIPs, geodata.MultiaddrsIPs)
+	_, downloads = srv.counts()
+	assert.Equal(t, 6, downloads)
+	assert.Equal(t, 6, store.opens)
+}
+
+func TestFeedCacheUnavailable(t *testing.T) {
+	srv := newFeedServer(t)
+	srv.down = true
+	cache := NewFeedCache(DirStore{Dir: t.TempDir()})
+	cache.Downloader.Backoff = time.Millisecond
+
+	_, err := cache.LoadGeoData(context.Background(), srv.policy(""))
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+
+	srv.Close()
+	_, err = cache.LoadGeoData(context.Background(), srv.policy(""))
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+}
+
+func TestDirStore(t *testing.T) {
+	store := DirStore{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	_, err := store.Stat(ctx, "feed.json")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	updated := time.Date(2022, 8, 8, 11, 0, 0, 0, time.UTC)
+	feed := Feed{URL: "https://example.com/feed.json", ETag: `"1"`, Updated: updated, Checked: updated}
+	assert.Nil(t, store.Put(ctx, "feed.json", strings.NewReader(`{"date": null}`), feed))
+
+	r, got, err := store.Open(ctx, "feed.json")
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, feed, got)
+	body, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, `{"date": null}`, string(body))
+}
+
+func TestOpenFeedStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := OpenFeedStore(dir)
+	assert.Nil(t, err)
+	assert.Equal(t, DirStore{Dir: dir}, store)
+
+	// Two containers with the same store: the second doesn't download
+	srv := newFeedServer(t)
+	store, err = OpenFeedStore("file://" + dir)
+	assert.Nil(t, err)
+	assert.Equal(t, DirStore{Dir: dir}, store)
+	for i := 0; i < 2; i++ {
+		cache := NewFeedCache(store)
+		cache.Downloader.TempDir = t.TempDir()
+		_, err = cache.LoadGeoData(context.Background(), srv.policy("10m"))
+		assert.Nil(t, err)
+	}
+	requests, downloads := srv.counts()
+	assert.Equal(t, 3, requests)
+	assert.Equal(t, 3, downloads)
+
+	_, err = OpenFeedStore("s3://kyc-feeds")
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+	_, err = OpenFeedStore("file://")
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+}
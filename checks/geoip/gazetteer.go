@@ -0,0 +1,199 @@
+package geoip
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/jftuga/geodist"
+)
+
+// GazetteerPlace is a populated place in a gazetteer.
+type GazetteerPlace struct {
+	Name           string
+	AlternateNames []string
+	// Admin1 is the code of the place's first-level division, e.g. the
+	// state in the US or the province in China.
+	Admin1     string
+	Country    string
+	Coord      geodist.Coord
+	Population int64
+}
+
+func (p *GazetteerPlace) address() Address {
+	addr := Address{
+		City:    p.Name,
+		Country: p.Country,
+	}
+	// Admin1 is a number in most countries, and the region code in a few
+	if isRegionCode(p.Admin1) && strings.Trim(p.Admin1, "0123456789") != "" {
+		addr.RegionCode = p.Admin1
+	}
+	addr.CityState = addr.Locality()
+	return addr
+}
+
+// Gazetteer geocodes cities offline, from a GeoNames-style table of
+// populated places, e.g. cities1000.txt from
+// https://download.geonames.org/export/dump/.
+type Gazetteer struct {
+	Path string
+	// byName indexes the places by country and name, for each of a place's
+	// names.
+	byName map[string][]*GazetteerPlace
+}
+
+// geonamesColumns is the number of tab-separated columns in a GeoNames
+// dump.
+const geonamesColumns = 19
+
+// ReadGazetteer reads the populated places, feature class P, of a GeoNames
+// dump. Other features are skipped.
+func ReadGazetteer(r io.Reader) (*Gazetteer, error) {
+	g := &Gazetteer{byName: map[string][]*GazetteerPlace{}}
+
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		cols := strings.Split(text, "\t")
+		if len(cols) != geonamesColumns {
+			return nil, fmt.Errorf("line %d: expected %d columns, found %d", line, geonamesColumns, len(cols))
+		}
+		if cols[6] != "P" {
+			continue
+		}
+		p, err := parseGazetteerPlace(cols)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		g.add(p, cols[2])
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func parseGazetteerPlace(cols []string) (*GazetteerPlace, error) {
+	lat, err := strconv.ParseFloat(cols[4], 64)
+	if err != nil {
+		return nil, fmt.Errorf("latitude: %w", err)
+	}
+	lon, err := strconv.ParseFloat(cols[5], 64)
+	if err != nil {
+		return nil, fmt.Errorf("longitude: %w", err)
+	}
+	var population int64
+	if cols[14] != "" {
+		population, err = strconv.ParseInt(cols[14], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("population: %w", err)
+		}
+	}
+	var alternateNames []string
+	if cols[3] != "" {
+		alternateNames = strings.Split(cols[3], ",")
+	}
+	return &GazetteerPlace{
+		Name:           cols[1],
+		AlternateNames: alternateNames,
+		Admin1:         cols[10],
+		Country:        strings.ToUpper(cols[8]),
+		Coord:          geodist.Coord{Lat: lat, Lon: lon},
+		Population:     population,
+	}, nil
+}
+
+func (g *Gazetteer) add(p *GazetteerPlace, asciiName string) {
+	seen := map[string]bool{}
+	for _, name := range append([]string{p.Name, asciiName}, p.AlternateNames...) {
+		key := gazetteerKey(p.Country, name)
+		if NormalizeCity(name) == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		g.byName[key] = append(g.byName[key], p)
+	}
+}
+
+func gazetteerKey(country, name string) string {
+	return strings.ToUpper(country) + "\t" + NormalizeCity(name)
+}
+
+// LoadGazetteer reads the GeoNames dump at path.
+func LoadGazetteer(path string) (*Gazetteer, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g, err := ReadGazetteer(f)
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", path, err)
+	}
+	g.Path = path
+	return g, nil
+}
+
+// Lookup returns the place called city in country. A place with city as
+// its main name beats one with it as an alternate name, and otherwise the
+// most populous place wins.
+func (g *Gazetteer) Lookup(city, country string) (*GazetteerPlace, bool) {
+	var best *GazetteerPlace
+	bestExact := false
+	for _, p := range g.byName[gazetteerKey(country, city)] {
+		exact := strings.EqualFold(p.Name, strings.TrimSpace(city))
+		switch {
+		case best == nil,
+			exact && !bestExact,
+			exact == bestExact && p.Population > best.Population:
+			best, bestExact = p, exact
+		}
+	}
+	return best, best != nil
+}
+
+func (*Gazetteer) Name() string {
+	return "gazetteer"
+}
+
+// Geocode returns the place Lookup finds, if any.
+func (g *Gazetteer) Geocode(_ context.Context, city, country string) ([]Place, error) {
+	p, ok := g.Lookup(city, country)
+	if !ok {
+		return nil, nil
+	}
+	return []Place{{Coord: p.Coord, Address: p.address()}}, nil
+}
+
+// sharedGazetteer is loaded on first use and then shared by every
+// invocation this container handles.
+var sharedGazetteer struct {
+	sync.Mutex
+	g *Gazetteer
+}
+
+// openGazetteer loads the gazetteer at path, e.g. one shipped in a Lambda
+// layer under /opt, unless it's already loaded.
+func openGazetteer(path string) (*Gazetteer, error) {
+	sharedGazetteer.Lock()
+	defer sharedGazetteer.Unlock()
+	if sharedGazetteer.g != nil && sharedGazetteer.g.Path == path {
+		return sharedGazetteer.g, nil
+	}
+	g, err := LoadGazetteer(path)
+	if err != nil {
+		return nil, err
+	}
+	sharedGazetteer.g = g
+	return g, nil
+}
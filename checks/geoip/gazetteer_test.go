@@ -0,0 +1,84 @@
+package geoip
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGazetteer(t *testing.T) {
+	g, err := LoadGazetteer("testdata/cities.txt")
+	assert.Nil(t, err)
+
+	cases := []struct {
+		city, country string
+		want          string // Locality
+		lat           float64
+	}{
+		{"Hangzhou", "CN", "Hangzhou", 30.29365},
+		{"杭州市", "CN", "Hangzhou", 30.29365},
+		{" hangzhou ", "cn", "Hangzhou", 30.29365},
+		{"Montreal", "CA", "Montréal", 45.50884},
+		{"Montréal", "CA", "Montréal", 45.50884},
+		{"MONTREAL, QC", "CA", "Montréal", 45.50884},
+		{"Hangzhou City", "CN", "Hangzhou", 30.29365},
+		// The most populous of several places with the same name.
+		{"San Jose", "US", "San Jose, CA", 37.33939},
+		{"San Jose", "CR", "San José", 9.93333},
+		{"Las Vegas", "US", "Las Vegas, NV", 36.17497},
+	}
+	for _, c := range cases {
+		places, err := g.Geocode(context.Background(), c.city, c.country)
+		assert.Nil(t, err)
+		if assert.Len(t, places, 1, "%s, %s", c.city, c.country) {
+			assert.Equal(t, c.want, places[0].Address.CityState)
+			assert.Equal(t, strings.ToUpper(c.country), places[0].Address.Country)
+			assert.Equal(t, c.lat, places[0].Coord.Lat)
+			assert.Nil(t, places[0].Google)
+		}
+	}
+
+	// Unknown, in another country, and not a populated place.
+	for _, c := range [][2]string{{"Wuzheng", "CN"}, {"Hangzhou", "US"}, {"San Jose Hills", "US"}} {
+		places, err := g.Geocode(context.Background(), c[0], c[1])
+		assert.Nil(t, err)
+		assert.Empty(t, places, "%s, %s", c[0], c[1])
+	}
+
+	_, err = ReadGazetteer(strings.NewReader("1808926\tHangzhou\tHangzhou\n"))
+	assert.ErrorContains(t, err, "line 1: expected 19 columns")
+}
+
+func TestGetGeocoder(t *testing.T) {
+	t.Setenv("GOOGLE_MAPS_API_KEY", "")
+	t.Setenv("GAZETTEER_PATH", "")
+	_, err := GetGeocoder()
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+
+	t.Setenv("GOOGLE_MAPS_API_KEY", "skip")
+	geocoder, err := GetGeocoder()
+	assert.Nil(t, err)
+	assert.Nil(t, geocoder)
+
+	t.Setenv("GAZETTEER_PATH", "testdata/cities.txt")
+	geocoder, err = GetGeocoder()
+	assert.Nil(t, err)
+	assert.IsType(t, &Gazetteer{}, geocoder)
+
+	// Google first, with the gazetteer for the cities it doesn't know.
+	t.Setenv("GOOGLE_MAPS_API_KEY", "test-key")
+	geocoder, err = GetGeocoder()
+	assert.Nil(t, err)
+	if assert.IsType(t, Geocoders{}, geocoder) {
+		assert.Equal(t, "google", geocoder.(Geocoders)[0].Name())
+		assert.Equal(t, "gazetteer", geocoder.(Geocoders)[1].Name())
+	}
+
+	t.Setenv("GOOGLE_MAPS_API_KEY", "skip")
+	t.Setenv("GAZETTEER_PATH", "testdata/missing.txt")
+	_, err = GetGeocoder()
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+}
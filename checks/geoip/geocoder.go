@@ -0,0 +1,104 @@
+package geoip
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/jftuga/geodist"
+	"googlemaps.github.io/maps"
+)
+
+// Place is where a geocoder puts a city.
+type Place struct {
+	// Coord is in WGS-84.
+	Coord   geodist.Coord
+	Address Address
+	// Google is the raw result, when the Google geocoder found the place.
+	Google *maps.GeocodingResult
+}
+
+// Geocoder finds the city a miner claims to be in.
+type Geocoder interface {
+	// Name identifies the geocoder in logs.
+	Name() string
+	// Geocode returns the places matching city in the country with ISO
+	// code country, best first, or none when it doesn't know the city.
+	Geocode(ctx context.Context, city, country string) ([]Place, error)
+}
+
+// Geocoders tries each geocoder in turn, until one knows the city.
+type Geocoders []Geocoder
+
+func (Geocoders) Name() string {
+	return "geocoders"
+}
+
+// Geocode returns the places found by the first geocoder that knows the
+// city. A geocoder's error is only returned when none of those after it
+// know the city either.
+func (gs Geocoders) Geocode(ctx context.Context, city, country string) ([]Place, error) {
+	var firstErr error
+	for _, g := range gs {
+		places, err := g.Geocode(ctx, city, country)
+		if err != nil {
+			log.Printf("Geocoding %s, %s with %s failed: %v\n", city, country, g.Name(), err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		if len(places) > 0 {
+			return places, nil
+		}
+	}
+	return nil, firstErr
+}
+
+// GetGeocoder returns the geocoders the environment configures: Google
+// with GOOGLE_MAPS_API_KEY, then the offline gazetteer at GAZETTEER_PATH.
+// It returns nil when GOOGLE_MAPS_API_KEY is "skip" and there's no
+// gazetteer, in which case only city names are matched.
+func GetGeocoder() (Geocoder, error) {
+	var geocoders Geocoders
+
+	gazetteerPath := os.Getenv("GAZETTEER_PATH")
+	if gazetteerPath == "" || os.Getenv("GOOGLE_MAPS_API_KEY") != "" {
+		client, err := GetGeocodeClient()
+		if err != nil {
+			return nil, err
+		}
+		if client != nil {
+			geocoders = append(geocoders, GoogleGeocoder{client})
+		}
+	}
+
+	if gazetteerPath != "" {
+		g, err := openGazetteer(gazetteerPath)
+		if err != nil {
+			return nil, checks.Errorf(checks.KindInternal, "gazetteer: %w", err)
+		}
+		geocoders = append(geocoders, g)
+	}
+
+	switch len(geocoders) {
+	case 0:
+		return nil, nil
+	case 1:
+		return geocoders[0], nil
+	}
+	return geocoders, nil
+}
+
+// geocode finds the miner's city, with no places when there's no geocoder.
+func geocode(ctx context.Context, geocoder Geocoder, city, country string) ([]Place, error) {
+	if geocoder == nil {
+		return nil, nil
+	}
+	places, err := geocoder.Geocode(ctx, city, country)
+	if err != nil {
+		return nil, checks.Errorf(checks.KindUpstreamUnavailable, "geocoding %s, %s: %w", city, country, err)
+	}
+	return places, nil
+}
@@ -2,21 +2,21 @@ package geoip
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"log"
-	"os"
-	"strconv"
+	"strings"
+	"time"
 
 	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/filecoin-project/go-state-types/abi"
 )
 
 type GeoIPCheck struct{}
 
-// func init() {
-// 	checks.Register(GeoIPCheck{})
-// }
+func init() {
+	checks.Register(&GeoIPCheck{})
+}
 
 type MinerData struct {
 	MinerID     string `json:"miner_id"`
@@ -24,58 +24,154 @@ type MinerData struct {
 	CountryCode string `json:"country_code"`
 }
 
-func (*GeoIPCheck) DoCheck(ctx context.Context, miner MinerData) (checks.NormalizedLocation, error) {
-	var err error
-	currentEpoch, err := strconv.ParseInt(os.Getenv("EPOCH"), 10, 64)
-	if currentEpoch == 0 || err != nil {
-		currentEpoch, err = GetCurrentEpoch(context.Background())
-		if err != nil {
-			log.Fatalf("Error getting current epoch: %v\n", err)
-		}
+func (*GeoIPCheck) Name() string {
+	return "geoip"
+}
+
+func (*GeoIPCheck) DoCheck(ctx context.Context, submission checks.FormSubmission, state *checks.State) (checks.Result, error) {
+	miner := MinerData{
+		MinerID:     submission.MinerID,
+		City:        submission.City,
+		CountryCode: submission.Country,
 	}
 
-	continentCodesJSON, err := ioutil.ReadFile("./continents.json")
+	if state.Policy == nil {
+		return checks.Result{}, checks.Errorf(checks.KindInternal, "no policy loaded")
+	}
+
+	country, err := ResolveCountry(miner.CountryCode)
 	if err != nil {
-		fmt.Println(err)
+		return checks.Result{}, err
 	}
+	miner.CountryCode = country.Alpha2
 
-	var continentCodes map[string]string
-	err = json.Unmarshal(continentCodesJSON, &continentCodes)
+	currentEpoch, err := getCurrentEpoch(ctx, state)
 	if err != nil {
-		fmt.Println(err)
+		return checks.Result{}, err
 	}
 
-	geodata, err := LoadGeoData()
+	// The chain has the miner's current addresses even when the feed lags,
+	// or can't be used at all
+	chainRecords, err := ChainIPs{API: state.Lotus}.MinerRecords(ctx, miner.MinerID, currentEpoch, epochTime(state, currentEpoch))
 	if err != nil {
-		return checks.NormalizedLocation{}, err
+		log.Printf("Using the multiaddrs-ips feed alone: %v\n", err)
+	}
+
+	geodata, stale, err := loadFeeds(ctx, state, currentEpoch)
+	var feedDates map[string]time.Time
+	switch {
+	case err == nil:
+		feedDates = geodata.Dates.byPolicyName()
+	case checks.KindOf(err) == checks.KindUpstreamUnavailable && len(chainRecords) > 0:
+		log.Printf("Using the on-chain addresses of %s alone: %v\n", miner.MinerID, err)
+		if geodata, err = chainOnlyGeoData(); err != nil {
+			return checks.Result{}, err
+		}
+	default:
+		return checks.Result{}, err
 	}
+	geodata = geodata.withChainRecords(chainRecords)
 
-	geocodeClient, err := GetGeocodeClient()
+	geocoder, err := GetGeocoder()
 	if err != nil {
-		return checks.NormalizedLocation{}, err
+		return checks.Result{}, err
 	}
 
-	ok, data, err := GeoMatchExists(context.Background(), geodata, geocodeClient, currentEpoch, miner)
+	ok, data, err := GeoMatchExists(ctx, geodata, geocoder, state.Policy.Geo, currentEpoch, miner)
+	if err != nil {
+		return checks.Result{}, err
+	}
 	if !ok {
-		return checks.NormalizedLocation{}, err
+		return checks.Result{
+			Status: checks.StatusFail,
+			Reason: fmt.Sprintf("no IP address of %s located near %s, %s",
+				miner.MinerID, miner.City, miner.CountryCode),
+			Evidence: checks.Evidence{
+				FeedDates:      feedDates,
+				StaleFeeds:     stale,
+				ExcludedIPs:    data.ExcludedIPs,
+				ProviderErrors: data.ProviderErrors,
+			},
+		}, nil
 	}
 
-	// TODO might not be necessary -- geodata has continent in it.
-	continent, ok := continentCodes[data.GeoDataAddresses[0].Country]
-	if !ok {
-		continent := continentCodes[miner.CountryCode]
-		if continent != "" {
-			fmt.Printf("Continent %s found for country %s ", continent, miner.CountryCode)
-		} else {
-			fmt.Println("Continent not found")
-		}
+	// Geocoding may have been skipped, in which case there's no address,
+	// and the location is the one submitted
+	address := Address{City: miner.City, Country: miner.CountryCode}
+	address.CityState = address.Locality()
+	if len(data.GeoDataAddresses) > 0 && data.GeoDataAddresses[0].Country != "" {
+		address = data.GeoDataAddresses[0]
+	}
+	continent, err := CountryContinent(address.Country)
+	if err != nil {
+		return checks.Result{}, err
 	}
 
-	response := checks.NormalizedLocation{
-		LocCity:      data.GeoDataAddresses[0].CityState,
-		LocCountry:   data.GeoDataAddresses[0].Country,
-		LocContinent: continent,
+	evidence := data.Match.evidence()
+	evidence.FeedDates = feedDates
+	evidence.StaleFeeds = stale
+	evidence.ExcludedIPs = data.ExcludedIPs
+	evidence.ProviderErrors = data.ProviderErrors
+
+	return checks.Result{
+		Status: checks.StatusPass,
+		Reason: fmt.Sprintf("%s located near %s, %s via %s",
+			miner.MinerID, miner.City, miner.CountryCode, data.Match.Provider),
+		Evidence: evidence,
+		Miner: checks.NormalizedMiner{
+			LocCity:      address.CityState,
+			LocCountry:   address.Country,
+			LocContinent: continent.Code,
+		},
+	}, nil
+}
+
+// loadFeeds loads the feeds, and returns the names of those older than the
+// policy allows but still used.
+func loadFeeds(ctx context.Context, state *checks.State, currentEpoch int64) (*GeoData, []string, error) {
+	geodata, err := LoadGeoData(ctx, state.Policy.Feeds)
+	if err != nil {
+		return nil, nil, err
+	}
+	stale, err := staleFeeds(geodata.Dates, state, currentEpoch)
+	if err != nil {
+		return nil, nil, err
+	}
+	return geodata, stale, nil
+}
+
+// staleFeeds returns the feeds generated longer before the current epoch
+// than the policy allows, or an error if the policy rejects stale feeds.
+func staleFeeds(dates FeedDates, state *checks.State, currentEpoch int64) ([]string, error) {
+	feeds := state.Policy.Feeds
+	maxStaleness := feeds.MaxStalenessDuration()
+	if maxStaleness == 0 {
+		return nil, nil
 	}
 
-	return response, nil
+	stale := dates.Stale(epochTime(state, currentEpoch), maxStaleness)
+	if len(stale) == 0 {
+		return nil, nil
+	}
+
+	generated := dates.byPolicyName()
+	details := make([]string, len(stale))
+	for i, name := range stale {
+		details[i] = fmt.Sprintf("%s generated %s", name, generated[name].UTC().Format(time.RFC3339))
+	}
+	if feeds.OnStale == checks.RejectStaleFeeds {
+		return nil, checks.Errorf(checks.KindUpstreamUnavailable, "feeds older than %s at epoch %d: %s",
+			maxStaleness, currentEpoch, strings.Join(details, ", "))
+	}
+	log.Printf("Using feeds older than %s at epoch %d: %s\n", maxStaleness, currentEpoch, strings.Join(details, ", "))
+	return stale, nil
+}
+
+// epochTime returns when epoch started.
+func epochTime(state *checks.State, epoch int64) time.Time {
+	clock := state.Clock
+	if clock == nil {
+		clock = chain.NewClock(chain.Mainnet)
+	}
+	return clock.EpochTime(abi.ChainEpoch(epoch))
 }
@@ -8,13 +8,16 @@ import (
 	"github.com/savaki/geoip2"
 )
 
-func getGeoIP2(ctx context.Context, ip string) (geoip2.Response, error) {
+// getGeoIP2 queries the MaxMind GeoIP2 Insights web service. ok is false
+// when no credentials are configured, or they are set to "skip".
+func getGeoIP2(ctx context.Context, ip string) (r geoip2.Response, ok bool, err error) {
 	userid := os.Getenv("MAXMIND_USER_ID")
 	key := os.Getenv("MAXMIND_LICENSE_KEY")
-	if userid == "skip" || key == "skip" {
+	if userid == "" || key == "" || userid == "skip" || key == "skip" {
 		log.Println("Warning: Skipping Maxmind GeoIP2 API lookups")
-		return geoip2.Response{}, nil
+		return geoip2.Response{}, false, nil
 	}
 	api := geoip2.New(userid, key)
-	return api.Insights(ctx, ip)
+	r, err = api.Insights(ctx, ip)
+	return r, err == nil, err
 }
@@ -2,12 +2,17 @@ package geoip
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
+	"time"
 )
 
 type IPsGeolite2Report struct {
 	Date *string                      `json:"date"`
 	IPs  map[string]IPsGeolite2Record `json:"ipsGeolite2"`
+	// Generated is Date parsed.
+	Generated time.Time `json:"-"`
 }
 
 type IPsGeolite2Record struct {
@@ -24,15 +29,41 @@ type IPsGeolite2Record struct {
 
 type Geolite2Detail map[string]interface{}
 
-func LoadIPsGeolite2(filepath string) (map[string]IPsGeolite2Record, error) {
+func LoadIPsGeolite2(filepath string) (*IPsGeolite2Report, error) {
 	if filepath == "" {
 		filepath = "testdata/ips-geolite2-latest.json"
 	}
-	bytes, err := os.ReadFile(filepath)
+	f, err := os.Open(filepath)
 	if err != nil {
 		return nil, err
 	}
-	var report IPsGeolite2Report
-	json.Unmarshal(bytes, &report)
-	return report.IPs, nil
+	defer f.Close()
+	return ReadIPsGeolite2(f)
+}
+
+// ReadIPsGeolite2 parses a ips-geolite2 feed as it streams in. A feed that isn't
+// valid JSON, or lacks its date or records, is an error rather than an
+// empty report.
+func ReadIPsGeolite2(r io.Reader) (*IPsGeolite2Report, error) {
+	report := IPsGeolite2Report{IPs: make(map[string]IPsGeolite2Record)}
+	date, found, err := decodeReport(r, "ips-geolite2", "ipsGeolite2", true, func(ip string, dec *json.Decoder) error {
+		var record IPsGeolite2Record
+		if err := dec.Decode(&record); err != nil {
+			return err
+		}
+		report.IPs[CanonicalIP(ip)] = record
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("parsing ips-geolite2 feed: no ipsGeolite2")
+	}
+	report.Date = date
+	report.Generated, err = parseFeedDate("ips-geolite2", date)
+	if err != nil {
+		return nil, err
+	}
+	return &report, nil
 }
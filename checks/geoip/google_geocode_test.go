@@ -0,0 +1,147 @@
+package geoip
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"googlemaps.github.io/maps"
+)
+
+// readGoogleResponse reads a recorded Geocoding API response.
+func readGoogleResponse(t *testing.T, name string) []maps.GeocodingResult {
+	b, err := os.ReadFile(path.Join("testdata/google", name))
+	assert.Nil(t, err)
+	var resp struct {
+		Results []maps.GeocodingResult `json:"results"`
+	}
+	assert.Nil(t, json.Unmarshal(b, &resp))
+	return resp.Results
+}
+
+func TestGetAddressComponents(t *testing.T) {
+	cases := []struct {
+		response string
+		want     Address
+	}{
+		{"us-san-jose.json", Address{
+			City: "San Jose", RegionCode: "CA", RegionName: "California", Country: "US",
+			CityState: "San Jose, CA",
+		}},
+		{"ca-montreal.json", Address{
+			City: "Montreal", RegionCode: "QC", RegionName: "Quebec", Country: "CA",
+			CityState: "Montreal, QC",
+		}},
+		{"cn-hangzhou.json", Address{
+			City: "Hangzhou", RegionName: "Zhejiang", Country: "CN",
+			CityState: "Hangzhou, Zhejiang",
+		}},
+		{"cn-beijing.json", Address{
+			City: "Beijing", RegionName: "Beijing", Country: "CN",
+			CityState: "Beijing",
+		}},
+		{"de-munich.json", Address{
+			City: "Munich", RegionCode: "BY", RegionName: "Bavaria", Country: "DE",
+			CityState: "Munich, Bavaria",
+		}},
+		{"de-berlin-alexanderplatz.json", Address{
+			StreetNumber: "1", Route: "Alexanderplatz",
+			City: "Berlin", RegionCode: "BE", RegionName: "Berlin", PostalCode: "10178", Country: "DE",
+			CityState: "Berlin",
+		}},
+		{"jp-tokyo.json", Address{
+			City: "Tokyo", RegionName: "Tokyo", Country: "JP",
+			CityState: "Tokyo",
+		}},
+		{"jp-shibuya.json", Address{
+			City: "Shibuya City", RegionName: "Tokyo", PostalCode: "150-0002", Country: "JP",
+			CityState: "Shibuya City, Tokyo",
+		}},
+	}
+	for _, c := range cases {
+		results := readGoogleResponse(t, c.response)
+		if !assert.Len(t, results, 1, c.response) {
+			continue
+		}
+		components := results[0].AddressComponents
+		assert.Equal(t, c.want, getAddressComponents(components), c.response)
+
+		// The order of the components doesn't matter
+		for i := 1; i < len(components); i++ {
+			rotated := append(append([]maps.AddressComponent{}, components[i:]...), components[:i]...)
+			assert.Equal(t, c.want, getAddressComponents(rotated), "%s rotated by %d", c.response, i)
+		}
+	}
+}
+
+func TestAddressLocality(t *testing.T) {
+	cases := []struct {
+		address Address
+		want    string
+	}{
+		{Address{City: "Las Vegas", RegionCode: "NV", RegionName: "Nevada", Country: "US"}, "Las Vegas, NV"},
+		{Address{City: "Las Vegas", RegionName: "Nevada", Country: "US"}, "Las Vegas, Nevada"},
+		{Address{City: "Las Vegas", Country: "US"}, "Las Vegas"},
+		{Address{City: "Sydney", RegionCode: "NSW", RegionName: "New South Wales", Country: "AU"}, "Sydney, NSW"},
+		{Address{City: "Warsaw", RegionCode: "14", RegionName: "Masovian Voivodeship", Country: "PL"}, "Warsaw, Masovian Voivodeship"},
+		{Address{City: "Hangzhou Shi", RegionName: "Hangzhou", Country: "CN"}, "Hangzhou Shi"},
+		{Address{RegionName: "Zhejiang", Country: "CN"}, "Zhejiang"},
+		{Address{Country: "CN"}, ""},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.want, c.address.Locality(), c.address.City)
+	}
+}
+
+// googleServer stands in for the Geocoding API, answering each address
+// with a recorded response.
+func googleServer(t *testing.T, responses map[string]string) *maps.Client {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := responses[r.URL.Query().Get("address")]
+		if !ok {
+			w.Write([]byte(`{"results": [], "status": "ZERO_RESULTS"}`))
+			return
+		}
+		http.ServeFile(w, r, path.Join("testdata/google", name))
+	}))
+	t.Cleanup(srv.Close)
+	client, err := maps.NewClient(maps.WithAPIKey("test-key"), maps.WithBaseURL(srv.URL))
+	assert.Nil(t, err)
+	return client
+}
+
+func TestGoogleGeocoder(t *testing.T) {
+	geocoder := GoogleGeocoder{googleServer(t, map[string]string{
+		"San Jose, US": "us-san-jose.json",
+		"Hangzhou, CN": "cn-hangzhou.json",
+	})}
+	ctx := context.Background()
+
+	places, err := geocoder.Geocode(ctx, "San Jose", "US")
+	assert.Nil(t, err)
+	if assert.Len(t, places, 1) {
+		assert.Equal(t, "San Jose, CA", places[0].Address.CityState)
+		assert.Equal(t, 37.3387474, places[0].Coord.Lat)
+		assert.NotNil(t, places[0].Google)
+	}
+
+	// Google's coordinates in mainland China are GCJ-02, a few hundred
+	// meters off WGS-84.
+	places, err = geocoder.Geocode(ctx, "Hangzhou", "CN")
+	assert.Nil(t, err)
+	if assert.Len(t, places, 1) {
+		assert.Equal(t, "Hangzhou, Zhejiang", places[0].Address.CityState)
+		assert.NotEqual(t, 30.274084, places[0].Coord.Lat)
+		assert.InDelta(t, 30.274084, places[0].Coord.Lat, 0.01)
+		assert.InDelta(t, 120.15507, places[0].Coord.Lon, 0.01)
+	}
+
+	places, err = geocoder.Geocode(ctx, "Atlantis", "US")
+	assert.Nil(t, err)
+	assert.Empty(t, places)
+}
@@ -0,0 +1,107 @@
+package geoip
+
+import (
+	"net/netip"
+	"strings"
+)
+
+// ParseIP parses an IP address in any of its textual forms, and
+// canonicalizes it so the same address is always looked up under the same
+// key: IPv4-mapped IPv6 addresses become IPv4, zones and brackets are
+// dropped, and IPv6 is in its RFC 5952 form.
+func ParseIP(s string) (netip.Addr, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return addr.Unmap().WithZone(""), nil
+}
+
+// CanonicalIP returns s in canonical form, or unchanged when it isn't an IP
+// address.
+func CanonicalIP(s string) string {
+	addr, err := ParseIP(s)
+	if err != nil {
+		return s
+	}
+	return addr.String()
+}
+
+// IPClass is the kind of address an IP is. Only public addresses say
+// anything about where a miner is.
+type IPClass string
+
+const (
+	IPPublic IPClass = "public"
+	// IPPrivate addresses are for networks that aren't connected to the
+	// internet, and can be reused by anyone.
+	IPPrivate IPClass = "private"
+	// IPReserved addresses are set aside for special purposes, such as
+	// loopback, link-local or documentation.
+	IPReserved IPClass = "reserved"
+	// IPCGNAT addresses are shared by the subscribers of a carrier-grade
+	// NAT, so locate the carrier at best.
+	IPCGNAT     IPClass = "cgnat"
+	IPMulticast IPClass = "multicast"
+	// IPBogon is anything else that can't appear on the internet: text that
+	// isn't an IP address, and IPv6 space not allocated for unicast.
+	IPBogon IPClass = "bogon"
+)
+
+// specialPurpose lists the non-public ranges of the IANA IPv4 and IPv6
+// Special-Purpose Address Registries, and multicast. Ranges are checked in
+// order, so more specific ones come first.
+var specialPurpose = []struct {
+	prefix netip.Prefix
+	class  IPClass
+	reason string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), IPReserved, "this network (RFC 791)"},
+	{netip.MustParsePrefix("10.0.0.0/8"), IPPrivate, "private-use (RFC 1918)"},
+	{netip.MustParsePrefix("100.64.0.0/10"), IPCGNAT, "shared address space (RFC 6598)"},
+	{netip.MustParsePrefix("127.0.0.0/8"), IPReserved, "loopback (RFC 1122)"},
+	{netip.MustParsePrefix("169.254.0.0/16"), IPReserved, "link-local (RFC 3927)"},
+	{netip.MustParsePrefix("172.16.0.0/12"), IPPrivate, "private-use (RFC 1918)"},
+	{netip.MustParsePrefix("192.0.0.0/24"), IPReserved, "IETF protocol assignments (RFC 6890)"},
+	{netip.MustParsePrefix("192.0.2.0/24"), IPReserved, "documentation (RFC 5737)"},
+	{netip.MustParsePrefix("192.88.99.0/24"), IPReserved, "6to4 relay anycast (RFC 7526)"},
+	{netip.MustParsePrefix("192.168.0.0/16"), IPPrivate, "private-use (RFC 1918)"},
+	{netip.MustParsePrefix("198.18.0.0/15"), IPReserved, "benchmarking (RFC 2544)"},
+	{netip.MustParsePrefix("198.51.100.0/24"), IPReserved, "documentation (RFC 5737)"},
+	{netip.MustParsePrefix("203.0.113.0/24"), IPReserved, "documentation (RFC 5737)"},
+	{netip.MustParsePrefix("224.0.0.0/4"), IPMulticast, "multicast (RFC 5771)"},
+	{netip.MustParsePrefix("240.0.0.0/4"), IPReserved, "reserved (RFC 1112)"},
+
+	{netip.MustParsePrefix("::/128"), IPReserved, "unspecified (RFC 4291)"},
+	{netip.MustParsePrefix("::1/128"), IPReserved, "loopback (RFC 4291)"},
+	{netip.MustParsePrefix("100::/64"), IPReserved, "discard-only (RFC 6666)"},
+	{netip.MustParsePrefix("2001:db8::/32"), IPReserved, "documentation (RFC 3849)"},
+	{netip.MustParsePrefix("2001::/23"), IPReserved, "IETF protocol assignments (RFC 2928)"},
+	{netip.MustParsePrefix("3fff::/20"), IPReserved, "documentation (RFC 9637)"},
+	{netip.MustParsePrefix("fc00::/7"), IPPrivate, "unique local (RFC 4193)"},
+	{netip.MustParsePrefix("fe80::/10"), IPReserved, "link-local (RFC 4291)"},
+	{netip.MustParsePrefix("ff00::/8"), IPMulticast, "multicast (RFC 4291)"},
+}
+
+// globalUnicast is the only IPv6 space allocated for public addresses.
+var globalUnicast = netip.MustParsePrefix("2000::/3")
+
+// ClassifyIP returns the class of addr and, for anything but a public
+// address, the range it falls in.
+func ClassifyIP(addr netip.Addr) (IPClass, string) {
+	if !addr.IsValid() {
+		return IPBogon, "not an IP address"
+	}
+	addr = addr.Unmap().WithZone("")
+	for _, r := range specialPurpose {
+		if r.prefix.Contains(addr) {
+			return r.class, r.reason
+		}
+	}
+	if addr.Is6() && !globalUnicast.Contains(addr) {
+		return IPBogon, "unallocated (outside 2000::/3)"
+	}
+	return IPPublic, ""
+}
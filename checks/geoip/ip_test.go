@@ -0,0 +1,173 @@
+package geoip
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIP(t *testing.T) {
+	cases := map[string]string{
+		"91.209.232.10":                           "91.209.232.10",
+		" 91.209.232.10 ":                         "91.209.232.10",
+		"::ffff:91.209.232.10":                    "91.209.232.10",
+		"2A00:1450:4001:0829:0000:0000:0000:200E": "2a00:1450:4001:829::200e",
+		"2a00:1450:4001:829:0:0:0:200e":           "2a00:1450:4001:829::200e",
+		"[2a00:1450:4001:829::200e]":              "2a00:1450:4001:829::200e",
+		"fe80::1%eth0":                            "fe80::1",
+	}
+	for in, want := range cases {
+		addr, err := ParseIP(in)
+		assert.Nil(t, err, in)
+		assert.Equal(t, want, addr.String(), in)
+		assert.Equal(t, want, CanonicalIP(in), in)
+	}
+
+	for _, in := range []string{"", "not an ip", "91.209.232", "91.209.232.10/24"} {
+		_, err := ParseIP(in)
+		assert.NotNil(t, err, in)
+		assert.Equal(t, in, CanonicalIP(in))
+	}
+}
+
+func TestClassifyIP(t *testing.T) {
+	cases := []struct {
+		ip    string
+		class IPClass
+	}{
+		{"91.209.232.10", IPPublic},
+		{"8.8.8.8", IPPublic},
+		{"100.128.0.1", IPPublic},
+		{"2a00:1450:4001:829::200e", IPPublic},
+		{"2606:4700:4700::1111", IPPublic},
+
+		{"10.1.2.3", IPPrivate},
+		{"172.16.0.1", IPPrivate},
+		{"172.31.255.255", IPPrivate},
+		{"192.168.1.1", IPPrivate},
+		{"::ffff:192.168.1.1", IPPrivate},
+		{"fd12:3456::1", IPPrivate},
+
+		{"0.0.0.0", IPReserved},
+		{"127.0.0.1", IPReserved},
+		{"169.254.10.10", IPReserved},
+		{"192.0.2.1", IPReserved},
+		{"198.18.0.1", IPReserved},
+		{"198.51.100.7", IPReserved},
+		{"203.0.113.9", IPReserved},
+		{"240.0.0.1", IPReserved},
+		{"255.255.255.255", IPReserved},
+		{"::", IPReserved},
+		{"::1", IPReserved},
+		{"fe80::1%eth0", IPReserved},
+		{"2001:db8::1", IPReserved},
+		{"3fff::1", IPReserved},
+
+		{"100.64.0.1", IPCGNAT},
+		{"100.127.255.254", IPCGNAT},
+
+		{"224.0.0.1", IPMulticast},
+		{"239.255.255.250", IPMulticast},
+		{"ff02::1", IPMulticast},
+
+		{"4000::1", IPBogon},
+		{"::2", IPBogon},
+	}
+	for _, c := range cases {
+		addr, err := ParseIP(c.ip)
+		assert.Nil(t, err, c.ip)
+		class, reason := ClassifyIP(addr)
+		assert.Equal(t, c.class, class, c.ip)
+		assert.Equal(t, c.class == IPPublic, reason == "", c.ip)
+	}
+
+	class, reason := ClassifyIP(netip.Addr{})
+	assert.Equal(t, IPBogon, class)
+	assert.Equal(t, "not an IP address", reason)
+	_, reason = ClassifyIP(netip.MustParseAddr("192.0.2.1"))
+	assert.Equal(t, "documentation (RFC 5737)", reason)
+}
+
+func TestIPv6Match(t *testing.T) {
+	if os.Getenv("IPINFO_TOKEN") == "" {
+		t.Setenv("IPINFO_TOKEN", "skip")
+	}
+	geodata, err := ReadGeoData(
+		strings.NewReader(`{"date": "2022-08-07T22:40:11.103Z", "multiaddrsIps": [
+			{"miner": "f0100", "ip": "::1", "epoch": 2055000},
+			{"miner": "f0100", "ip": "::ffff:10.0.0.1", "epoch": 2055000},
+			{"miner": "f0100", "ip": "10.0.0.1", "epoch": 2055000},
+			{"miner": "f0100", "ip": "fe80::1%eth0", "epoch": 2055000},
+			{"miner": "f0100", "ip": "100.64.1.2", "epoch": 2055000},
+			{"miner": "f0100", "ip": "bogus", "epoch": 2055000},
+			{"miner": "f0100", "ip": "2A00:1450:4001:0829:0000:0000:0000:200E", "epoch": 2055000}
+		]}`),
+		strings.NewReader(`{"date": "2022-08-07T22:45:02.771Z", "ipsGeolite2": {
+			"2a00:1450:4001:829:0:0:0:200e": {"country": "PL", "city": "Warsaw"}
+		}}`),
+		strings.NewReader(`{"date": "2022-08-07T22:49:44.248Z", "ipsBaidu": {}}`),
+	)
+	assert.Nil(t, err)
+	assert.Len(t, geodata.IPRecords("2a00:1450:4001:829::200e"), 1)
+	assert.Len(t, geodata.IPRecords("[2A00:1450:4001:829::200E]"), 1)
+
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	geo := policy.Geo
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "geolite2"}}
+
+	ok, data, err := GeoMatchExists(context.Background(), geodata, nil, geo, 2055000, MinerData{"f0100", "Warsaw", "PL"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "2a00:1450:4001:829::200e", data.Match.IP)
+	assert.Equal(t, []checks.ExcludedIP{
+		{IP: "::1", Class: "reserved", Reason: "loopback (RFC 4291)"},
+		{IP: "10.0.0.1", Class: "private", Reason: "private-use (RFC 1918)"},
+		{IP: "fe80::1", Class: "reserved", Reason: "link-local (RFC 4291)"},
+		{IP: "100.64.1.2", Class: "cgnat", Reason: "shared address space (RFC 6598)"},
+		{IP: "bogus", Class: "bogon", Reason: "not an IP address"},
+	}, data.ExcludedIPs)
+}
+
+// Feeds may well place private and reserved addresses somewhere. Announcing
+// one must not be enough to pass.
+func TestNonPublicIPsNotEvidence(t *testing.T) {
+	if os.Getenv("IPINFO_TOKEN") == "" {
+		t.Setenv("IPINFO_TOKEN", "skip")
+	}
+	geodata, err := ReadGeoData(
+		strings.NewReader(`{"date": "2022-08-07T22:40:11.103Z", "multiaddrsIps": [
+			{"miner": "f0100", "ip": "192.168.0.10", "epoch": 2055000},
+			{"miner": "f0100", "ip": "203.0.113.10", "epoch": 2055000},
+			{"miner": "f0100", "ip": "239.1.1.1", "epoch": 2055000}
+		]}`),
+		strings.NewReader(`{"date": "2022-08-07T22:45:02.771Z", "ipsGeolite2": {
+			"192.168.0.10": {"country": "PL", "city": "Warsaw"},
+			"203.0.113.10": {"country": "PL", "city": "Warsaw"},
+			"239.1.1.1": {"country": "PL", "city": "Warsaw"}
+		}}`),
+		strings.NewReader(`{"date": "2022-08-07T22:49:44.248Z", "ipsBaidu": {}}`),
+	)
+	assert.Nil(t, err)
+
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	geo := policy.Geo
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "geolite2"}}
+
+	ok, data, err := GeoMatchExists(context.Background(), geodata, nil, geo, 2055000, MinerData{"f0100", "Warsaw", "PL"})
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	assert.Empty(t, data.GeoData.MultiaddrsIPs)
+	assert.Empty(t, data.IPLocations)
+	var classes []string
+	for _, e := range data.ExcludedIPs {
+		classes = append(classes, e.Class)
+	}
+	assert.Equal(t, []string{"private", "reserved", "multicast"}, classes)
+}
@@ -5,15 +5,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
-	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jftuga/geodist"
 	"github.com/pkg/errors"
 )
 
+// DefaultIPInfoBaseURL is where IPINFO_BASE_URL points when it's not set.
+const DefaultIPInfoBaseURL = "https://ipinfo.io"
+
 type IPInfoResolver struct {
-	Continents map[string]string
+	// BaseURL is the ipinfo API, or a stand-in serving the same responses.
+	BaseURL string
+	// Token is sent with each request. Lookups are skipped without one.
+	Token  string
+	Client *http.Client
 }
 
 type IPInfoResponse struct {
@@ -35,57 +46,106 @@ type IPInfoResponse struct {
 	} `json:"asn"`
 }
 
-func NewIPInfoResolver() (*IPInfoResolver, error) {
-	payload := make(map[string]string)
+// Coord parses Location, "lat,lon". It returns nil when ipinfo has no
+// coordinates for the IP.
+func (r IPInfoResponse) Coord() (*geodist.Coord, error) {
+	if r.Location == "" {
+		return nil, nil
+	}
+	lat, lon, ok := strings.Cut(r.Location, ",")
+	if !ok {
+		return nil, errors.Errorf("ipinfo: malformed loc %q", r.Location)
+	}
+	latF, err := strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ipinfo: malformed loc %q", r.Location)
+	}
+	lonF, err := strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err != nil {
+		return nil, errors.Wrapf(err, "ipinfo: malformed loc %q", r.Location)
+	}
+	return &geodist.Coord{Lat: latF, Lon: lonF}, nil
+}
 
-	if err := json.Unmarshal(CountryToContinentJSON, &payload); err != nil {
-		return &IPInfoResolver{}, errors.Wrap(err, "ipinfo: failed to unmarshal continents")
+// AS returns the autonomous system of the IP. Only paid plans include the
+// asn object; the others carry it in org, as in "AS15169 Google LLC".
+func (r IPInfoResponse) AS() (asn string, name string) {
+	if r.ASN.ASN != "" {
+		return r.ASN.ASN, r.ASN.Name
+	}
+	if asn, name, _ := strings.Cut(r.Org, " "); strings.HasPrefix(asn, "AS") {
+		return asn, name
+	}
+	return "", r.Org
+}
+
+// NewIPInfoResolver configures a resolver from IPINFO_BASE_URL and
+// IPINFO_TOKEN.
+func NewIPInfoResolver() (*IPInfoResolver, error) {
+	baseURL := os.Getenv("IPINFO_BASE_URL")
+	if baseURL == "" {
+		baseURL = DefaultIPInfoBaseURL
 	}
 
 	return &IPInfoResolver{
-		Continents: payload,
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Token:   os.Getenv("IPINFO_TOKEN"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
 	}, nil
 }
 
-func (i *IPInfoResolver) ResolveIP(ctx context.Context, ip net.IP) (map[string]IPInfoResponse, error) {
-	url := fmt.Sprintf("https://ipinfo.io/%s?token=%s", ip, os.Getenv("IPINFO_TOKEN"))
+// Enabled reports whether the resolver has a token to query ipinfo with.
+func (i *IPInfoResolver) Enabled() bool {
+	return i.Token != "" && i.Token != "skip"
+}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+func (i *IPInfoResolver) ResolveIP(ctx context.Context, ip netip.Addr) (IPInfoResponse, error) {
+	endpoint := fmt.Sprintf("%s/%s", i.BaseURL, ip)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
 
 	if err != nil {
-		return map[string]IPInfoResponse{}, errors.Wrap(err, "failed to create http request")
+		return IPInfoResponse{}, errors.Wrap(err, "failed to create http request")
 	}
 
 	req.Header.Set("Accept", "application/json")
-	client := &http.Client{}
+	// In a header rather than the URL, which errors from client.Do quote
+	req.Header.Set("Authorization", "Bearer "+i.Token)
+	client := i.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
 
 	resp, err := client.Do(req)
 
 	if err != nil {
-		return map[string]IPInfoResponse{}, errors.Wrap(err, "failed to resolve IP")
+		return IPInfoResponse{}, errors.Wrap(err, "failed to resolve IP")
 	}
 
 	defer resp.Body.Close()
 
-	payload := make(map[string]IPInfoResponse)
+	if resp.StatusCode != http.StatusOK {
+		return IPInfoResponse{}, errors.Errorf("ipinfo: unexpected status %s", resp.Status)
+	}
 
 	body, err := io.ReadAll(resp.Body)
 
 	if err != nil {
-		return map[string]IPInfoResponse{}, errors.Wrap(err, "failed to read response body")
+		return IPInfoResponse{}, errors.Wrap(err, "failed to read response body")
 	}
 
+	var payload IPInfoResponse
 	if err := json.Unmarshal(body, &payload); err != nil {
-		return map[string]IPInfoResponse{}, errors.Wrap(err, "ipinfo: failed to unmarshal response")
+		return IPInfoResponse{}, errors.Wrap(err, "ipinfo: failed to unmarshal response")
 	}
 
 	return payload, nil
 }
 
 func (i *IPInfoResolver) ResolveIPStr(ctx context.Context, ip string) (string, error) {
-	parsed := net.ParseIP(ip)
-	if parsed == nil {
-		return "", errors.Errorf("failed to parse IP address %s", ip)
+	parsed, err := ParseIP(ip)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to parse IP address %s", ip)
 	}
 
 	result, err := i.ResolveIP(ctx, parsed)
@@ -94,5 +154,5 @@ func (i *IPInfoResolver) ResolveIPStr(ctx context.Context, ip string) (string, e
 		return "", errors.Wrap(err, "failed to resolve IP")
 	}
 
-	return result[ip].Country, nil
+	return result.Country, nil
 }
@@ -0,0 +1,178 @@
+package geoip
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/jftuga/geodist"
+	"github.com/stretchr/testify/assert"
+)
+
+// ipinfoResponses are recorded ipinfo.io responses, keyed by IP.
+var ipinfoResponses = map[string]string{
+	"91.209.232.10": `{
+		"ip": "91.209.232.10",
+		"city": "Warsaw",
+		"region": "Mazovia",
+		"country": "PL",
+		"loc": "52.2298,21.0118",
+		"org": "AS201814 MEVSPACE sp. z o.o.",
+		"postal": "00-001",
+		"timezone": "Europe/Warsaw"
+	}`,
+	"142.113.86.4": `{
+		"ip": "142.113.86.4",
+		"city": "Ottawa",
+		"region": "Ontario",
+		"country": "CA",
+		"loc": "45.4112,-75.6981",
+		"postal": "K1P",
+		"timezone": "America/Toronto",
+		"asn": {
+			"asn": "AS577",
+			"name": "Bell Canada",
+			"domain": "bell.ca",
+			"route": "142.112.0.0/14",
+			"type": "isp"
+		}
+	}`,
+}
+
+// newIPInfoServer serves ipinfoResponses the way ipinfo.io does, and sets
+// IPINFO_BASE_URL and IPINFO_TOKEN to use it.
+func newIPInfoServer(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" || r.URL.RawQuery != "" {
+			http.Error(w, `{"error": "invalid token"}`, http.StatusForbidden)
+			return
+		}
+		body, ok := ipinfoResponses[strings.TrimPrefix(r.URL.Path, "/")]
+		if !ok {
+			http.Error(w, `{"error": "not found"}`, http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+
+	t.Setenv("IPINFO_BASE_URL", srv.URL)
+	t.Setenv("IPINFO_TOKEN", "test-token")
+}
+
+func TestIPInfoResolver(t *testing.T) {
+	newIPInfoServer(t)
+
+	resolver, err := NewIPInfoResolver()
+	assert.Nil(t, err)
+	assert.True(t, resolver.Enabled())
+
+	r, err := resolver.ResolveIP(context.Background(), netip.MustParseAddr("91.209.232.10"))
+	assert.Nil(t, err)
+	assert.Equal(t, "Warsaw", r.City)
+	coord, err := r.Coord()
+	assert.Nil(t, err)
+	assert.Equal(t, &geodist.Coord{Lat: 52.2298, Lon: 21.0118}, coord)
+	asn, name := r.AS()
+	assert.Equal(t, "AS201814", asn)
+	assert.Equal(t, "MEVSPACE sp. z o.o.", name)
+
+	country, err := resolver.ResolveIPStr(context.Background(), "142.113.86.4")
+	assert.Nil(t, err)
+	assert.Equal(t, "CA", country)
+
+	_, err = resolver.ResolveIPStr(context.Background(), "192.0.2.1")
+	assert.NotNil(t, err)
+
+	resolver.Token = "wrong"
+	_, err = resolver.ResolveIPStr(context.Background(), "91.209.232.10")
+	assert.NotNil(t, err)
+
+	_, err = IPInfoResponse{Location: "north"}.Coord()
+	assert.NotNil(t, err)
+}
+
+func TestIPInfoResolverHidesToken(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+	resolver := &IPInfoResolver{BaseURL: srv.URL, Token: "SECRET123"}
+
+	_, err := resolver.ResolveIP(context.Background(), netip.MustParseAddr("8.8.8.8"))
+	if assert.NotNil(t, err) {
+		assert.NotContains(t, err.Error(), "SECRET123")
+	}
+	_, err = ipinfoProvider{resolver}.Locate(context.Background(), netip.MustParseAddr("8.8.8.8"))
+	if assert.NotNil(t, err) {
+		assert.NotContains(t, err.Error(), "SECRET123")
+	}
+}
+
+func TestIPInfoMatch(t *testing.T) {
+	newIPInfoServer(t)
+
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	geo := policy.Geo
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "ipinfo"}}
+
+	ok, data, err := GeoMatchExists(context.Background(), geodata, nil, geo, 2055000,
+		MinerData{"f01558688", "Ottawa", "CA"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	if assert.NotNil(t, data.Match) {
+		assert.Equal(t, checks.Evidence{
+			MatchedIP:       "142.113.86.4",
+			MatchedProvider: "ipinfo",
+			CityMatch:       "exact",
+			CitySimilarity:  1,
+			ASN:             "AS577",
+			ASName:          "Bell Canada",
+		}, data.Match.evidence())
+	}
+
+	// Montreal is geocoded about 165 km from where ipinfo places the IP.
+	montreal := []geodist.Coord{{Lat: 45.5019, Lon: -73.5674}}
+	g, _, err := geodata.filterByMinerID(context.Background(), "f01558688", 2055000, geo.IPMaxAgeEpochs)
+	assert.Nil(t, err)
+	match, _, _, err := findMatch(context.Background(), ipinfoProvider{g.Ipinfo}, g,
+		MinerData{"f01558688", "Montreal", "CA"}, montreal, geo.MaxDistanceKmFor("CA"), geo.MinCitySimilarity)
+	assert.Nil(t, err)
+	if assert.NotNil(t, match) {
+		assert.NotNil(t, match.DistanceKm)
+		assert.Equal(t, "AS577", match.ASN)
+	}
+
+	// The stand-in knows nothing about f01012's IP: with nothing else to
+	// try, an upstream error.
+	_, data, err = GeoMatchExists(context.Background(), geodata, nil, geo, 2055000,
+		MinerData{"f01012", "Hangzhou", "CN"})
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+	assert.Len(t, data.ProviderErrors, 1)
+
+	// Otherwise the error is recorded, and the next provider tried.
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "ipinfo"}, {Name: "baidu"}}
+	ok, data, err = GeoMatchExists(context.Background(), geodata, nil, geo, 2055000,
+		MinerData{"f01012", "Hangzhou", "CN"})
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	if assert.NotNil(t, data.Match) {
+		assert.Equal(t, "baidu", data.Match.Provider)
+	}
+	if assert.Len(t, data.ProviderErrors, 1) {
+		assert.Equal(t, "ipinfo", data.ProviderErrors[0].Provider)
+		assert.Equal(t, "115.236.46.164", data.ProviderErrors[0].IP)
+		assert.Contains(t, data.ProviderErrors[0].Error, "404")
+	}
+}
@@ -0,0 +1,177 @@
+package geoip
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// MMDB is a GeoLite2-City or GeoIP2-City database file. It's reopened when
+// the file changes, so an updated database can be dropped in place.
+type MMDB struct {
+	Path string
+
+	mu      sync.RWMutex
+	reader  *maxminddb.Reader
+	modTime time.Time
+	size    int64
+}
+
+// mmdbCity is the part of a City database record the checks use.
+type mmdbCity struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		AccuracyRadius uint16  `maxminddb:"accuracy_radius"`
+		Latitude       float64 `maxminddb:"latitude"`
+		Longitude      float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Subdivisions []struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+// OpenMMDB opens the database at path.
+func OpenMMDB(path string) (*MMDB, error) {
+	db := &MMDB{Path: path}
+	if err := db.reload(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// reload reopens the file if it changed since it was last opened.
+func (db *MMDB) reload() error {
+	info, err := os.Stat(db.Path)
+	if err != nil {
+		return checks.Errorf(checks.KindInternal, "mmdb: %w", err)
+	}
+
+	db.mu.RLock()
+	current := db.reader != nil && info.ModTime().Equal(db.modTime) && info.Size() == db.size
+	db.mu.RUnlock()
+	if current {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.reader != nil && info.ModTime().Equal(db.modTime) && info.Size() == db.size {
+		return nil
+	}
+	reader, err := maxminddb.Open(db.Path)
+	if err != nil {
+		return checks.Errorf(checks.KindInternal, "mmdb: opening %s: %w", db.Path, err)
+	}
+	if db.reader != nil {
+		db.reader.Close()
+	}
+	log.Printf("Loaded %s database %s, built %s\n", reader.Metadata.DatabaseType, db.Path,
+		time.Unix(int64(reader.Metadata.BuildEpoch), 0).UTC().Format(time.RFC3339))
+	db.reader, db.modTime, db.size = reader, info.ModTime(), info.Size()
+	return nil
+}
+
+// DatabaseType is the type recorded in the database, e.g. "GeoLite2-City".
+func (db *MMDB) DatabaseType() string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.reader.Metadata.DatabaseType
+}
+
+// Lookup returns nil when the database has no record for ip.
+func (db *MMDB) Lookup(ip netip.Addr) (*IPLocation, error) {
+	if !ip.IsValid() {
+		return nil, nil
+	}
+	if err := db.reload(); err != nil {
+		return nil, err
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	var r mmdbCity
+	_, ok, err := db.reader.LookupNetwork(net.IP(ip.AsSlice()), &r)
+	if err != nil {
+		return nil, checks.Errorf(checks.KindInternal, "mmdb: looking up %s: %w", ip, err)
+	}
+	if !ok || r.Country.IsoCode == "" {
+		return nil, nil
+	}
+
+	loc := &IPLocation{
+		IP:               ip,
+		Source:           "mmdb",
+		CountryCode:      r.Country.IsoCode,
+		City:             r.City.Names["en"],
+		AccuracyRadiusKm: float64(r.Location.AccuracyRadius),
+		Timestamp:        time.Unix(int64(db.reader.Metadata.BuildEpoch), 0).UTC(),
+	}
+	loc.Coord, loc.CoordSystem = maxmindCoord(r.Location.Latitude, r.Location.Longitude)
+	if len(r.Subdivisions) > 0 {
+		loc.Subdivision = r.Subdivisions[0].Names["en"]
+	}
+	return loc, nil
+}
+
+// sharedMMDB is opened on first use and then shared by every invocation
+// this container handles.
+var sharedMMDB struct {
+	sync.Mutex
+	db *MMDB
+}
+
+// openMMDB opens the database named by MAXMIND_DB_PATH, e.g. one shipped
+// in a Lambda layer under /opt. It returns nil when the variable isn't set.
+func openMMDB() (*MMDB, error) {
+	path := os.Getenv("MAXMIND_DB_PATH")
+	if path == "" {
+		return nil, nil
+	}
+
+	sharedMMDB.Lock()
+	defer sharedMMDB.Unlock()
+	if sharedMMDB.db != nil && sharedMMDB.db.Path == path {
+		return sharedMMDB.db, nil
+	}
+	db, err := OpenMMDB(path)
+	if err != nil {
+		return nil, err
+	}
+	sharedMMDB.db = db
+	return db, nil
+}
+
+type mmdbProvider struct{}
+
+func (mmdbProvider) Name() string {
+	return "mmdb"
+}
+
+// CountryLevel is true for GeoIP2 databases, which like the web service
+// leave the city out rather than guess it.
+func (mmdbProvider) CountryLevel() bool {
+	db, err := openMMDB()
+	return err == nil && db != nil && strings.HasPrefix(db.DatabaseType(), "GeoIP2")
+}
+
+func (mmdbProvider) Locate(_ context.Context, ip netip.Addr) (*IPLocation, error) {
+	db, err := openMMDB()
+	if err != nil || db == nil {
+		return nil, err
+	}
+	return db.Lookup(ip)
+}
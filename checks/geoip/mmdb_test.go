@@ -0,0 +1,103 @@
+package geoip
+
+import (
+	"context"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/stretchr/testify/assert"
+)
+
+func copyFile(t *testing.T, src, dest string, modTime time.Time) {
+	data, err := os.ReadFile(src)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(dest, data, 0644))
+	assert.Nil(t, os.Chtimes(dest, modTime, modTime))
+}
+
+func TestMMDB(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "GeoLite2-City.mmdb")
+	start := time.Now().Add(-time.Hour)
+	copyFile(t, "testdata/GeoLite2-City-Test.mmdb", path, start)
+
+	db, err := OpenMMDB(path)
+	assert.Nil(t, err)
+	assert.Equal(t, "GeoLite2-City", db.DatabaseType())
+
+	loc, err := db.Lookup(netip.MustParseAddr("91.209.232.10"))
+	assert.Nil(t, err)
+	if assert.NotNil(t, loc) {
+		assert.Equal(t, "PL", loc.CountryCode)
+		assert.Equal(t, "Mazovia", loc.Subdivision)
+		assert.Equal(t, "Warsaw", loc.City)
+		assert.Equal(t, 52.2296, loc.Coord.Lat)
+		assert.Equal(t, 20.0, loc.AccuracyRadiusKm)
+		assert.Equal(t, "mmdb", loc.Source)
+	}
+
+	loc, err = db.Lookup(netip.MustParseAddr("192.0.2.1"))
+	assert.Nil(t, err)
+	assert.Nil(t, loc)
+
+	// A new database dropped in place is picked up by the next lookup.
+	copyFile(t, "testdata/GeoIP2-City-Test.mmdb", path, start.Add(time.Minute))
+	loc, err = db.Lookup(netip.MustParseAddr("91.209.232.10"))
+	assert.Nil(t, err)
+	if assert.NotNil(t, loc) {
+		assert.Equal(t, "Krakow", loc.City)
+	}
+	assert.Equal(t, "GeoIP2-City", db.DatabaseType())
+
+	assert.Nil(t, os.Remove(path))
+	_, err = db.Lookup(netip.MustParseAddr("91.209.232.10"))
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+
+	_, err = OpenMMDB("testdata/ips-geolite2-latest.json")
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+}
+
+func TestMMDBProvider(t *testing.T) {
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+
+	policy, err := checks.ParsePolicy(checks.DefaultPolicyJSON)
+	assert.Nil(t, err)
+	geo := policy.Geo
+	geo.Providers = []checks.GeoProviderPolicy{{Name: "mmdb"}}
+
+	match := func(miner MinerData) *GeoMatch {
+		ok, data, err := GeoMatchExists(context.Background(), geodata, nil, geo, 2055000, miner)
+		assert.Nil(t, err)
+		assert.Equal(t, ok, data.Match != nil)
+		return data.Match
+	}
+
+	// Skipped without a database.
+	t.Setenv("MAXMIND_DB_PATH", "")
+	assert.Nil(t, match(MinerData{"f02620", "Warsaw", "PL"}))
+
+	t.Setenv("MAXMIND_DB_PATH", "testdata/GeoLite2-City-Test.mmdb")
+	if m := match(MinerData{"f02620", "Warsaw", "PL"}); assert.NotNil(t, m) {
+		assert.Equal(t, "mmdb", m.Provider)
+	}
+	if m := match(MinerData{"f01558688", "Montreal", "CA"}); assert.NotNil(t, m) {
+		assert.Equal(t, "142.113.86.4", m.IP)
+	}
+	// GeoLite2 has no city for this IP, and isn't trusted at country level.
+	assert.Nil(t, match(MinerData{"f01736668", "Omaha", "US"}))
+
+	t.Setenv("MAXMIND_DB_PATH", "testdata/GeoIP2-City-Test.mmdb")
+	assert.NotNil(t, match(MinerData{"f01736668", "Omaha", "US"}))
+
+	t.Setenv("MAXMIND_DB_PATH", "testdata/missing.mmdb")
+	_, _, err = GeoMatchExists(context.Background(), geodata, nil, geo, 2055000, MinerData{"f02620", "Warsaw", "PL"})
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+}
@@ -2,12 +2,18 @@ package geoip
 
 import (
 	"encoding/json"
+	"fmt"
+	"io"
+	"net/netip"
 	"os"
+	"time"
 )
 
 type MultiaddrsIPsReport struct {
 	Date          *string
 	MultiaddrsIPs []MultiaddrsIPsRecord
+	// Generated is Date parsed.
+	Generated time.Time `json:"-"`
 }
 
 type MultiaddrsIPsRecord struct {
@@ -19,17 +25,59 @@ type MultiaddrsIPsRecord struct {
 	Timestamp string `json:"timestamp"`
 	DHT       bool   `json:"dht"`
 	Chain     bool   `json:"chain"`
+	// Addr is IP parsed, and invalid if it couldn't be.
+	Addr netip.Addr `json:"-"`
 }
 
-func LoadMultiAddrsIPs(filepath string) ([]MultiaddrsIPsRecord, error) {
+// addr returns the record's IP address, parsing IP if the record wasn't
+// read from a feed.
+func (m MultiaddrsIPsRecord) addr() netip.Addr {
+	if m.Addr.IsValid() {
+		return m.Addr
+	}
+	addr, _ := ParseIP(m.IP)
+	return addr
+}
+
+func LoadMultiAddrsIPs(filepath string) (*MultiaddrsIPsReport, error) {
 	if filepath == "" {
 		filepath = "testdata/multiaddrs-ips-latest.json"
 	}
-	bytes, err := os.ReadFile(filepath)
+	f, err := os.Open(filepath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadMultiAddrsIPs(f)
+}
+
+// ReadMultiAddrsIPs parses a multiaddrs-ips feed as it streams in. A feed
+// that isn't valid JSON, or lacks its date or records, is an error rather
+// than an empty report.
+func ReadMultiAddrsIPs(r io.Reader) (*MultiaddrsIPsReport, error) {
+	report := MultiaddrsIPsReport{MultiaddrsIPs: []MultiaddrsIPsRecord{}}
+	date, found, err := decodeReport(r, "multiaddrs-ips", "multiaddrsIps", false, func(_ string, dec *json.Decoder) error {
+		var record MultiaddrsIPsRecord
+		err := dec.Decode(&record)
+		if err != nil {
+			return err
+		}
+		if record.Addr, err = ParseIP(record.IP); err == nil {
+			record.IP = record.Addr.String()
+		}
+		report.MultiaddrsIPs = append(report.MultiaddrsIPs, record)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, fmt.Errorf("parsing multiaddrs-ips feed: no multiaddrsIps")
+	}
+	report.Date = date
+	report.Generated, err = parseFeedDate("multiaddrs-ips", date)
 	if err != nil {
 		return nil, err
 	}
-	var report MultiaddrsIPsReport
-	json.Unmarshal(bytes, &report)
-	return report.MultiaddrsIPs, nil
+	return &report, nil
 }
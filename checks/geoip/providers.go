@@ -0,0 +1,253 @@
+package geoip
+
+import (
+	"context"
+	"log"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/geoip/coords"
+	"github.com/jftuga/geodist"
+	"github.com/savaki/geoip2"
+)
+
+// IPLocation is where a provider places an IP address, in the same shape
+// whatever the provider.
+type IPLocation struct {
+	IP     netip.Addr `json:"ip"`
+	Source string     `json:"source"`
+	// CountryCode is an ISO 3166-1 alpha-2 code.
+	CountryCode string `json:"country_code"`
+	Subdivision string `json:"subdivision,omitempty"`
+	City        string `json:"city,omitempty"`
+	// Coord is nil when the provider has no coordinates for the IP.
+	Coord *geodist.Coord `json:"coord,omitempty"`
+	// CoordSystem is the coordinate system of Coord, as the provider
+	// reports it.
+	CoordSystem coords.System `json:"coord_system,omitempty"`
+	// AccuracyRadiusKm is zero when the provider doesn't say.
+	AccuracyRadiusKm float64   `json:"accuracy_radius_km,omitempty"`
+	Timestamp        time.Time `json:"timestamp,omitempty"`
+	// ASN is the autonomous system announcing the IP, as in "AS15169", for
+	// providers that know it.
+	ASN    string `json:"asn,omitempty"`
+	ASName string `json:"as_name,omitempty"`
+}
+
+// GeoProvider is a source of IP geolocation data.
+type GeoProvider interface {
+	// Name identifies the provider in the policy and in evidence.
+	Name() string
+	// Locate returns nil when the provider knows nothing about ip.
+	Locate(ctx context.Context, ip netip.Addr) (*IPLocation, error)
+}
+
+// CountryLevelProvider is implemented by providers whose country is enough
+// to match a miner when they have no city for an IP.
+type CountryLevelProvider interface {
+	GeoProvider
+	CountryLevel() bool
+}
+
+// geoProviders builds each provider a policy can list in geo.providers over
+// the data loaded for a miner. Adding a source means adding an adapter here.
+var geoProviders = map[string]func(g *GeoData) GeoProvider{
+	"baidu":    func(g *GeoData) GeoProvider { return baiduProvider(g.IPsBaidu) },
+	"geolite2": func(g *GeoData) GeoProvider { return geolite2Provider(g.IPsGeolite2) },
+	"mmdb":     func(g *GeoData) GeoProvider { return mmdbProvider{} },
+	"geoip2":   func(g *GeoData) GeoProvider { return geoip2Provider(g.IPsGeoIP2) },
+	"ipinfo":   func(g *GeoData) GeoProvider { return ipinfoProvider{g.Ipinfo} },
+}
+
+// providerChain builds the providers of policy that apply to country, in
+// order.
+func providerChain(g *GeoData, policy checks.GeoPolicy, country string) ([]GeoProvider, error) {
+	var chain []GeoProvider
+	for _, p := range policy.Providers {
+		newProvider, ok := geoProviders[p.Name]
+		if !ok {
+			return nil, checks.Errorf(checks.KindInternal, "unknown geo provider %q", p.Name)
+		}
+		if p.AppliesTo(country) {
+			chain = append(chain, newProvider(g))
+		}
+	}
+	return chain, nil
+}
+
+func parseTimestamp(s string) time.Time {
+	t, _ := time.Parse(time.RFC3339, s)
+	return t
+}
+
+type geolite2Provider map[string]IPsGeolite2Record
+
+func (geolite2Provider) Name() string {
+	return "geolite2"
+}
+
+func (p geolite2Provider) Locate(_ context.Context, ip netip.Addr) (*IPLocation, error) {
+	r, ok := p[ip.String()]
+	if !ok {
+		return nil, nil
+	}
+	loc := &IPLocation{
+		IP:          ip,
+		Source:      "geolite2",
+		CountryCode: r.Country,
+		Subdivision: r.Subdiv1,
+		City:        r.City,
+		Timestamp:   parseTimestamp(r.Timestamp),
+	}
+	l, _ := r.Geolite2["location"].(map[string]interface{})
+	lat, latOk := l["latitude"].(float64)
+	lon, lonOk := l["longitude"].(float64)
+	if latOk && lonOk {
+		loc.Coord = &geodist.Coord{Lat: lat, Lon: lon}
+		loc.CoordSystem = coords.WGS84
+	}
+	loc.AccuracyRadiusKm, _ = l["accuracy_radius"].(float64)
+	return loc, nil
+}
+
+type baiduProvider map[string]IPsBaiduRecord
+
+func (baiduProvider) Name() string {
+	return "baidu"
+}
+
+func (p baiduProvider) Locate(_ context.Context, ip netip.Addr) (*IPLocation, error) {
+	r, ok := p[ip.String()]
+	if !ok {
+		return nil, nil
+	}
+	loc := &IPLocation{
+		IP:          ip,
+		Source:      "baidu",
+		CountryCode: "CN",
+		City:        r.City,
+		Timestamp:   parseTimestamp(r.Timestamp),
+	}
+	// "CN|浙江|杭州|None|CHINANET|0|0"
+	if address, _ := r.Baidu["address"].(string); address != "" {
+		if country := strings.Split(address, "|")[0]; country != "" {
+			loc.CountryCode = country
+		}
+	}
+
+	content, _ := r.Baidu["content"].(map[string]interface{})
+	detail, _ := content["address_detail"].(map[string]interface{})
+	loc.Subdivision, _ = detail["province"].(string)
+
+	point, _ := content["point"].(map[string]interface{})
+	x, _ := point["x"].(string)
+	y, _ := point["y"].(string)
+	lon, err := strconv.ParseFloat(x, 64)
+	if err != nil {
+		log.Println("Error parsing baidu longitude (x)", err)
+		return loc, nil
+	}
+	lat, err := strconv.ParseFloat(y, 64)
+	if err != nil {
+		log.Println("Error parsing baidu latitude (y)", err)
+		return loc, nil
+	}
+	// Baidu points are BD-09, in degrees unless Mercator projected.
+	loc.Coord = &geodist.Coord{Lat: lat, Lon: lon}
+	loc.CoordSystem = coords.BD09
+	if coords.IsMercator(*loc.Coord) {
+		loc.CoordSystem = coords.BD09MC
+	}
+	return loc, nil
+}
+
+// maxmindCoord is the point of a MaxMind location, or nil when the record
+// has none: a missing location reads as (0, 0), in the Gulf of Guinea.
+func maxmindCoord(lat, lon float64) (*geodist.Coord, coords.System) {
+	if lat == 0 && lon == 0 {
+		return nil, ""
+	}
+	return &geodist.Coord{Lat: lat, Lon: lon}, coords.WGS84
+}
+
+// geoip2Provider queries the GeoIP2 web service, keeping the responses so
+// each IP is only looked up once.
+type geoip2Provider map[string]geoip2.Response
+
+func (geoip2Provider) Name() string {
+	return "geoip2"
+}
+
+// CountryLevel is true: GeoIP2 leaves the city out when it isn't confident
+// about it, rather than guessing.
+func (geoip2Provider) CountryLevel() bool {
+	return true
+}
+
+func (p geoip2Provider) Locate(ctx context.Context, ip netip.Addr) (*IPLocation, error) {
+	r, ok := p[ip.String()]
+	if !ok {
+		var err error
+		r, ok, err = getGeoIP2(ctx, ip.String())
+		if err != nil {
+			return nil, checks.Errorf(checks.KindUpstreamUnavailable, "geoip2 lookup of %s: %w", ip, err)
+		}
+		if !ok {
+			return nil, nil
+		}
+		p[ip.String()] = r
+	}
+	loc := &IPLocation{
+		IP:               ip,
+		Source:           "geoip2",
+		CountryCode:      r.Country.IsoCode,
+		City:             r.City.Names["en"],
+		AccuracyRadiusKm: float64(r.Location.AccuracyRadius),
+		Timestamp:        time.Now(),
+	}
+	loc.Coord, loc.CoordSystem = maxmindCoord(r.Location.Latitude, r.Location.Longitude)
+	if len(r.Subdivisions) > 0 {
+		loc.Subdivision = r.Subdivisions[0].Names["en"]
+	}
+	return loc, nil
+}
+
+type ipinfoProvider struct {
+	resolver *IPInfoResolver
+}
+
+func (ipinfoProvider) Name() string {
+	return "ipinfo"
+}
+
+func (p ipinfoProvider) Locate(ctx context.Context, ip netip.Addr) (*IPLocation, error) {
+	if p.resolver == nil || !p.resolver.Enabled() {
+		return nil, nil
+	}
+	r, err := p.resolver.ResolveIP(ctx, ip)
+	if err != nil {
+		return nil, checks.Errorf(checks.KindUpstreamUnavailable, "ipinfo lookup of %s: %w", ip, err)
+	}
+	if r.Country == "" {
+		return nil, nil
+	}
+	loc := &IPLocation{
+		IP:          ip,
+		Source:      "ipinfo",
+		CountryCode: r.Country,
+		Subdivision: r.Region,
+		City:        r.City,
+		Timestamp:   time.Now(),
+	}
+	loc.ASN, loc.ASName = r.AS()
+	if loc.Coord, err = r.Coord(); err != nil {
+		log.Printf("Ignoring ipinfo location of %s: %v\n", ip, err)
+	}
+	if loc.Coord != nil {
+		loc.CoordSystem = coords.WGS84
+	}
+	return loc, nil
+}
@@ -0,0 +1,311 @@
+package geoip
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/geoip/coords"
+	"github.com/jftuga/geodist"
+	"github.com/savaki/geoip2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProviders(t *testing.T) {
+	geodata, err := LoadGeoDataFiles(
+		"testdata/multiaddrs-ips-latest.json",
+		"testdata/ips-geolite2-latest.json",
+		"testdata/ips-baidu-latest.json",
+	)
+	assert.Nil(t, err)
+
+	var warsaw geoip2.Response
+	warsaw.Country.IsoCode = "PL"
+	warsaw.City.Names = map[string]string{"en": "Warsaw"}
+	warsaw.Location.Latitude = 52.2296
+	warsaw.Location.Longitude = 21.0067
+	warsaw.Location.AccuracyRadius = 20
+	geodata.IPsGeoIP2["91.209.232.10"] = warsaw
+
+	cases := []struct {
+		provider string
+		ip       string
+		want     IPLocation
+	}{
+		{
+			provider: "geolite2",
+			ip:       "91.209.232.10",
+			want: IPLocation{
+				CountryCode:      "PL",
+				Subdivision:      "Mazovia",
+				City:             "Warsaw",
+				Coord:            &geodist.Coord{Lat: 52.2296, Lon: 21.0067},
+				CoordSystem:      coords.WGS84,
+				AccuracyRadiusKm: 20,
+			},
+		},
+		{
+			provider: "baidu",
+			ip:       "115.236.46.164",
+			want: IPLocation{
+				CountryCode: "CN",
+				Subdivision: "浙江省",
+				City:        "Hangzhou",
+				Coord:       &geodist.Coord{Lat: 30.25924446, Lon: 120.21937542},
+				CoordSystem: coords.BD09,
+			},
+		},
+		{
+			provider: "geoip2",
+			ip:       "91.209.232.10",
+			want: IPLocation{
+				CountryCode:      "PL",
+				City:             "Warsaw",
+				Coord:            &geodist.Coord{Lat: 52.2296, Lon: 21.0067},
+				CoordSystem:      coords.WGS84,
+				AccuracyRadiusKm: 20,
+			},
+		},
+	}
+
+	for _, c := range cases {
+		provider := geoProviders[c.provider](geodata)
+		assert.Equal(t, c.provider, provider.Name())
+
+		ip := netip.MustParseAddr(c.ip)
+		loc, err := provider.Locate(context.Background(), ip)
+		assert.Nil(t, err)
+		if !assert.NotNil(t, loc, c.provider) {
+			continue
+		}
+		assert.False(t, loc.Timestamp.IsZero(), c.provider)
+		loc.Timestamp = c.want.Timestamp
+		c.want.IP = ip
+		c.want.Source = c.provider
+		assert.Equal(t, c.want, *loc, c.provider)
+
+		loc, err = provider.Locate(context.Background(), netip.MustParseAddr("192.0.2.1"))
+		assert.Nil(t, err)
+		assert.Nil(t, loc, c.provider)
+	}
+	// A record without a location has no coordinates, rather than (0, 0),
+	// and can't match on distance
+	var unlocated geoip2.Response
+	unlocated.Country.IsoCode = "GH"
+	unlocated.City.Names = map[string]string{"en": "Accra"}
+	geodata.IPsGeoIP2["192.0.2.1"] = unlocated
+	provider := geoProviders["geoip2"](geodata)
+	loc, err := provider.Locate(context.Background(), netip.MustParseAddr("192.0.2.1"))
+	assert.Nil(t, err)
+	if assert.NotNil(t, loc) {
+		assert.Nil(t, loc.Coord)
+		assert.Equal(t, coords.System(""), loc.CoordSystem)
+	}
+	g := &GeoData{MultiaddrsIPs: []MultiaddrsIPsRecord{{Miner: "f01000", IP: "192.0.2.1"}}}
+	match, _, _, err := findMatch(context.Background(), provider, g, MinerData{"f01000", "Takoradi", "GH"},
+		[]geodist.Coord{{Lat: 4.8845, Lon: -1.7554}}, 1000, checks.DefaultMinCitySimilarity)
+	assert.Nil(t, err)
+	assert.Nil(t, match)
+}
+
+type fakeProvider struct {
+	name         string
+	locations    map[string]IPLocation
+	countryLevel bool
+	// err fails the lookups of the IPs it has no location for.
+	err error
+}
+
+func (p fakeProvider) Name() string {
+	if p.name != "" {
+		return p.name
+	}
+	return "fake"
+}
+
+func (p fakeProvider) Locate(_ context.Context, ip netip.Addr) (*IPLocation, error) {
+	loc, ok := p.locations[ip.String()]
+	if !ok {
+		return nil, p.err
+	}
+	loc.IP = ip
+	loc.Source = p.Name()
+	return &loc, nil
+}
+
+func (p fakeProvider) CountryLevel() bool {
+	return p.countryLevel
+}
+
+func TestFindMatch(t *testing.T) {
+	g := &GeoData{MultiaddrsIPs: []MultiaddrsIPsRecord{
+		{Miner: "f01000", IP: "192.0.2.1"},
+		{Miner: "f01000", IP: "192.0.2.1"},
+		{Miner: "f01000", IP: "192.0.2.2"},
+	}}
+	ottawa := geodist.Coord{Lat: 45.4112, Lon: -75.6981}
+	montreal := geodist.Coord{Lat: 45.5019, Lon: -73.5674}
+	miner := MinerData{"f01000", "Montreal", "CA"}
+
+	cases := []struct {
+		name      string
+		provider  fakeProvider
+		locations []geodist.Coord
+		want      *GeoMatch
+	}{
+		{
+			name: "city name",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.2": {CountryCode: "CA", City: "Montreal"},
+			}},
+			want: &GeoMatch{IP: "192.0.2.2", Provider: "fake", City: "Montreal", CityMatch: CityExact, CitySimilarity: 1},
+		},
+		{
+			name: "normalized city name",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.2": {CountryCode: "CA", City: "Montréal"},
+			}},
+			want: &GeoMatch{IP: "192.0.2.2", Provider: "fake", City: "Montréal", CityMatch: CityNormalized, CitySimilarity: 1},
+		},
+		{
+			name: "fuzzy city name",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.2": {CountryCode: "CA", City: "Montreall"},
+			}},
+			want: &GeoMatch{IP: "192.0.2.2", Provider: "fake", City: "Montreall", CityMatch: CityFuzzy, CitySimilarity: 1 - 1.0/9},
+		},
+		{
+			name: "different city name",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.2": {CountryCode: "CA", City: "Montréal-Est"},
+			}},
+		},
+		{
+			name: "wrong country",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.1": {CountryCode: "US", City: "Montreal"},
+			}},
+		},
+		{
+			name: "distance",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.1": {CountryCode: "CA", City: "Ottawa", Coord: &ottawa},
+			}},
+			locations: []geodist.Coord{montreal},
+			want:      &GeoMatch{IP: "192.0.2.1", Provider: "fake"},
+		},
+		{
+			name: "no city, country level",
+			provider: fakeProvider{countryLevel: true, locations: map[string]IPLocation{
+				"192.0.2.1": {CountryCode: "CA"},
+			}},
+			want: &GeoMatch{IP: "192.0.2.1", Provider: "fake"},
+		},
+		{
+			name: "no city",
+			provider: fakeProvider{locations: map[string]IPLocation{
+				"192.0.2.1": {CountryCode: "CA"},
+			}},
+		},
+	}
+
+	// Baidu points are converted to WGS-84 before measuring: the same spot
+	// in BD-09, or Baidu Mercator, is well within a kilometer.
+	hangzhou := geodist.Coord{Lat: 30.2741, Lon: 120.1551}
+	bd09, err := coords.Convert(hangzhou, coords.WGS84, coords.BD09)
+	assert.Nil(t, err)
+	bd09mc, err := coords.Convert(hangzhou, coords.WGS84, coords.BD09MC)
+	assert.Nil(t, err)
+	for system, coord := range map[coords.System]geodist.Coord{coords.BD09: bd09, coords.BD09MC: bd09mc} {
+		provider := fakeProvider{locations: map[string]IPLocation{
+			"192.0.2.1": {CountryCode: "CN", Coord: &coord, CoordSystem: system},
+		}}
+		match, _, _, err := findMatch(context.Background(), provider, g, MinerData{"f01000", "Xihu", "CN"},
+			[]geodist.Coord{hangzhou}, 0.1, checks.DefaultMinCitySimilarity)
+		assert.Nil(t, err)
+		if assert.NotNil(t, match, system) {
+			assert.Less(t, *match.DistanceKm, 0.01, system)
+		}
+	}
+
+	for _, c := range cases {
+		match, found, failed, err := findMatch(context.Background(), c.provider, g, miner, c.locations, 200, checks.DefaultMinCitySimilarity)
+		assert.Nil(t, err, c.name)
+		assert.Empty(t, failed, c.name)
+		assert.Len(t, found, len(c.provider.locations), c.name)
+		if match != nil {
+			match.DistanceKm = nil
+		}
+		assert.Equal(t, c.want, match, c.name)
+	}
+
+	// A lookup that fails is skipped, and the next IP may match
+	unavailable := checks.Errorf(checks.KindUpstreamUnavailable, "rate limited")
+	provider := fakeProvider{err: unavailable, locations: map[string]IPLocation{
+		"192.0.2.2": {CountryCode: "CA", City: "Montreal"},
+	}}
+	match, found, failed, err := findMatch(context.Background(), provider, g, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Nil(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, []checks.ProviderError{{Provider: "fake", IP: "192.0.2.1", Error: "rate limited"}}, failed)
+	if assert.NotNil(t, match) {
+		assert.Equal(t, "192.0.2.2", match.IP)
+	}
+
+	// Nothing is looked up after a match
+	provider = fakeProvider{err: unavailable, locations: map[string]IPLocation{
+		"192.0.2.1": {CountryCode: "CA", City: "Montreal"},
+	}}
+	match, _, failed, err = findMatch(context.Background(), provider, g, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Nil(t, err)
+	assert.Empty(t, failed)
+	if assert.NotNil(t, match) {
+		assert.Equal(t, "192.0.2.1", match.IP)
+	}
+
+	// Unless every lookup fails
+	_, _, failed, err = findMatch(context.Background(), fakeProvider{err: unavailable}, g, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+	assert.Len(t, failed, 2)
+}
+
+func TestMatchChain(t *testing.T) {
+	g := &GeoData{MultiaddrsIPs: []MultiaddrsIPsRecord{{Miner: "f01000", IP: "192.0.2.1"}}}
+	miner := MinerData{"f01000", "Montreal", "CA"}
+	down := fakeProvider{name: "down", err: checks.Errorf(checks.KindUpstreamUnavailable, "service unavailable")}
+	montreal := fakeProvider{name: "up", locations: map[string]IPLocation{
+		"192.0.2.1": {CountryCode: "CA", City: "Montreal"},
+	}}
+
+	// A provider that fails doesn't stop the ones after it
+	data := FinalGeoData{GeoData: g}
+	err := data.matchChain(context.Background(), []GeoProvider{down, montreal}, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Nil(t, err)
+	if assert.NotNil(t, data.Match) {
+		assert.Equal(t, "up", data.Match.Provider)
+	}
+	assert.Equal(t, []checks.ProviderError{{Provider: "down", IP: "192.0.2.1", Error: "service unavailable"}}, data.ProviderErrors)
+	assert.Len(t, data.IPLocations, 1)
+
+	// No match, but not every provider failed
+	data = FinalGeoData{GeoData: g}
+	err = data.matchChain(context.Background(), []GeoProvider{down, fakeProvider{}}, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Nil(t, err)
+	assert.Nil(t, data.Match)
+	assert.Len(t, data.ProviderErrors, 1)
+
+	data = FinalGeoData{GeoData: g}
+	err = data.matchChain(context.Background(), []GeoProvider{down, down}, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+	assert.ErrorContains(t, err, "service unavailable")
+	assert.Nil(t, data.Match)
+	assert.Len(t, data.ProviderErrors, 2)
+
+	// Anything but an unavailable provider stops the chain
+	broken := fakeProvider{name: "broken", err: checks.Errorf(checks.KindInternal, "misconfigured")}
+	data = FinalGeoData{GeoData: g}
+	err = data.matchChain(context.Background(), []GeoProvider{broken, montreal}, miner, nil, 200, checks.DefaultMinCitySimilarity)
+	assert.Equal(t, checks.KindInternal, checks.KindOf(err))
+	assert.Nil(t, data.Match)
+}
@@ -4,3 +4,9 @@ import _ "embed"
 
 //go:embed country-to-continent.json
 var CountryToContinentJSON []byte
+
+// ISO3166JSON lists the ISO 3166-1 countries, with Kosovo's user-assigned
+// code, and the other names applicants and providers give them.
+//
+//go:embed iso3166.json
+var ISO3166JSON []byte
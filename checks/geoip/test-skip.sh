@@ -2,6 +2,7 @@
 
 export GOOGLE_MAPS_API_KEY=skip
 export MAXMIND_USER_ID=skip
+export IPINFO_TOKEN=skip
 
 go test
 
@@ -5,6 +5,7 @@
 export GOOGLE_MAPS_API_KEY
 export MAXMIND_USER_ID
 export MAXMIND_LICENSE_KEY
+export IPINFO_TOKEN
 
 go test
 
@@ -4,51 +4,89 @@ import (
 	"context"
 	"log"
 	"math/big"
-	"net/http"
 
-	"github.com/filecoin-project/go-address"
-	jsonrpc "github.com/filecoin-project/go-jsonrpc"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
 	lotusapi "github.com/filecoin-project/lotus/api"
 	"github.com/filecoin-project/lotus/chain/types"
 )
 
-// LookupPower gets the power for the miner from the Lotus API
-func LookupPower(ctx context.Context, miner string) (*lotusapi.MinerPower, error) {
-	headers := http.Header{}
-	api_addr := "api.chain.love"
-
-	var api lotusapi.FullNodeStruct
-	closer, err := jsonrpc.NewMergeClient(ctx,
-		"wss://"+api_addr+"/rpc/v0", "Filecoin",
-		[]interface{}{&api.Internal, &api.CommonStruct.Internal}, headers)
+type PowerCheck struct{}
+
+func init() {
+	checks.Register(&PowerCheck{})
+}
+
+func (*PowerCheck) Name() string {
+	return "minpower"
+}
+
+func (*PowerCheck) DoCheck(ctx context.Context, submission checks.FormSubmission, state *checks.State) (checks.Result, error) {
+	if state.Policy == nil {
+		return checks.Result{}, checks.Errorf(checks.KindInternal, "no policy loaded")
+	}
+	min := state.Policy.MinPowerBytes()
+
+	power, err := LookupPower(ctx, state.Lotus, submission.MinerID)
 	if err != nil {
-		log.Fatalf("connecting with lotus failed: %s", err)
+		return checks.Result{}, err
+	}
+
+	evidence := checks.Evidence{
+		QualityAdjPower: power.MinerPower.QualityAdjPower.String(),
+		MinPower:        min.String(),
 	}
-	defer closer()
+	if !powerOk(power, min) {
+		return checks.Result{
+			Status:   checks.StatusFail,
+			Reason:   "miner power too low",
+			Evidence: evidence,
+		}, nil
+	}
+
+	return checks.Result{
+		Status:   checks.StatusPass,
+		Reason:   "miner has enough quality adjusted power",
+		Evidence: evidence,
+	}, nil
+}
 
-	addr, err := address.NewFromString(miner)
+// LookupPower gets the power for the miner from the Lotus API
+func LookupPower(ctx context.Context, api chain.API, miner string) (*lotusapi.MinerPower, error) {
+	addr, err := checks.ParseMinerID(miner)
 	if err != nil {
 		return nil, err
 	}
 
+	if api == nil {
+		return nil, checks.Errorf(checks.KindInternal, "no lotus client configured")
+	}
+
 	power, err := api.StateMinerPower(ctx, addr, types.EmptyTSK)
+	if chain.IsActorNotFound(err) {
+		return nil, checks.Errorf(checks.KindInvalidInput, "no miner %s on chain: %w", miner, err)
+	}
 	if err != nil {
-		return nil, err
+		return nil, checks.Errorf(checks.KindUpstreamUnavailable, "looking up power for %s: %w", miner, err)
 	}
 	log.Printf("Miner power %s: %v\n", miner, power)
 	return power, nil
 }
 
 // MinQualityPowerOk compares the power from the API for miner against a minimum
-func MinQualityPowerOk(ctx context.Context, miner string, min *big.Int) (bool, error) {
-	power, err := LookupPower(ctx, miner)
+func MinQualityPowerOk(ctx context.Context, api chain.API, miner string, min *big.Int) (bool, error) {
+	power, err := LookupPower(ctx, api, miner)
 	if err != nil {
 		return false, err
 	}
-	if power.MinerPower.QualityAdjPower.Cmp(min) < 0 {
+	if !powerOk(power, min) {
 		log.Printf("Insufficient power %s: %v < %v\n", miner,
 			power.MinerPower.QualityAdjPower, min)
 		return false, nil
 	}
 	return true, nil
 }
+
+func powerOk(power *lotusapi.MinerPower, min *big.Int) bool {
+	return power.MinerPower.QualityAdjPower.Cmp(min) >= 0
+}
@@ -2,10 +2,14 @@ package minpower
 
 import (
 	"context"
+	"errors"
 	"math/big"
 	"os"
 	"testing"
 
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain/chaintest"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,16 +24,42 @@ func TestMinPower(t *testing.T) {
 
 	minerID := os.Getenv("MINER_ID")
 
+	cfg, err := chain.ConfigFromEnv()
+	assert.Nil(t, err)
+
 	cases := make([]TestCase, 0)
 	if minerID == "" {
+		cfg = chain.Config{Endpoint: chaintest.NewServer(t, chaintest.Fixtures)}
 		cases = append(cases, TestCase{"f01000", false})
 		cases = append(cases, TestCase{"f02620", true})
 	} else {
 		cases = append(cases, TestCase{minerID, true})
 	}
+
+	api, err := chain.Dial(context.Background(), cfg)
+	if !assert.Nil(t, err) {
+		return
+	}
+	defer api.Close()
+
 	for _, c := range cases {
-		ok, err := MinQualityPowerOk(context.Background(), c.in, min)
+		ok, err := MinQualityPowerOk(context.Background(), api, c.in, min)
 		assert.Equal(t, c.want, ok)
 		assert.Nil(t, err)
 	}
 }
+
+func TestLookupPowerInvalidMiner(t *testing.T) {
+	for _, miner := range []string{"", "f0abc", "not a miner"} {
+		_, err := LookupPower(context.Background(), &chaintest.Fake{}, miner)
+		assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err), miner)
+	}
+}
+
+func TestLookupPowerUnknownMiner(t *testing.T) {
+	_, err := LookupPower(context.Background(), &chaintest.Fake{}, "f01234")
+	assert.Equal(t, checks.KindInvalidInput, checks.KindOf(err))
+
+	_, err = LookupPower(context.Background(), chain.Unavailable(errors.New("down")), "f01234")
+	assert.Equal(t, checks.KindUpstreamUnavailable, checks.KindOf(err))
+}
@@ -0,0 +1,128 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Run executes each check in turn against submission.MinerID, merging its
+// contribution into state.Miner and recording its verdict in state.Checks.
+// Once a check fails or errors, the remaining checks are recorded as
+// skipped and the returned error says which check stopped the pipeline. A
+// failed verdict is a KindCheckFailed error; a check's own error keeps its
+// Kind.
+func Run(ctx context.Context, pipeline []Check, submission FormSubmission, state *State) error {
+	var stopped error
+	for _, c := range pipeline {
+		if stopped != nil {
+			state.Checks = append(state.Checks, CheckResult{
+				MinerID: submission.MinerID,
+				Check:   c.Name(),
+				Status:  StatusSkip,
+				Reason:  fmt.Sprintf("not run: %v", stopped),
+			})
+			continue
+		}
+
+		result, err := c.DoCheck(ctx, submission, state)
+		if err != nil {
+			result = Result{Status: StatusError, Reason: err.Error()}
+			stopped = fmt.Errorf("%s: %w", c.Name(), err)
+		} else if result.Status == StatusFail {
+			stopped = Errorf(KindCheckFailed, "%s: %s", c.Name(), result.Reason)
+		}
+
+		state.Miner.Merge(result.Miner)
+		state.Checks = append(state.Checks, CheckResult{
+			MinerID:  submission.MinerID,
+			Check:    c.Name(),
+			Status:   result.Status,
+			Reason:   result.Reason,
+			Evidence: result.Evidence,
+		})
+	}
+	return stopped
+}
+
+// MinerRun is the outcome of the pipeline for one miner.
+type MinerRun struct {
+	MinerID string
+	State   *State
+	Err     error
+}
+
+// RunMiners runs the pipeline for every miner in the submission
+// concurrently. Each miner gets its own copy of shared, so checks only
+// share the clients and policy it holds. The runs are returned in
+// submission order.
+func RunMiners(ctx context.Context, pipeline []Check, submission FormSubmission, shared State) []MinerRun {
+	miners := submission.Miners()
+	runs := make([]MinerRun, len(miners))
+
+	var wg sync.WaitGroup
+	for i, minerID := range miners {
+		state := shared
+		state.Miner = NormalizedMiner{}
+		state.Checks = nil
+		runs[i] = MinerRun{MinerID: minerID, State: &state}
+
+		wg.Add(1)
+		go func(run *MinerRun) {
+			defer wg.Done()
+			run.Err = Run(ctx, pipeline, submission.ForMiner(run.MinerID), run.State)
+		}(&runs[i])
+	}
+	wg.Wait()
+
+	return runs
+}
+
+// MinerPass decides whether a multi-miner submission passes.
+type MinerPass string
+
+const (
+	// AllMinersMustPass rejects the submission if any miner fails.
+	AllMinersMustPass MinerPass = "all"
+	// AnyMinerMayPass accepts the submission if at least one miner passes.
+	AnyMinerMayPass MinerPass = "any"
+)
+
+// Verdict combines the per-miner runs under mode. It returns nil when the
+// submission passes. Otherwise a failed check takes precedence when every
+// miner had to pass, since retrying can't change it; when any miner may
+// pass, an upstream or internal error takes precedence, since a retry might.
+func (mode MinerPass) Verdict(runs []MinerRun) error {
+	var failed, errored []MinerRun
+	for _, run := range runs {
+		switch {
+		case run.Err == nil:
+			if mode == AnyMinerMayPass {
+				return nil
+			}
+		case KindOf(run.Err) == KindCheckFailed:
+			failed = append(failed, run)
+		default:
+			errored = append(errored, run)
+		}
+	}
+
+	if len(failed) == 0 && len(errored) == 0 {
+		return nil
+	}
+
+	first := failed
+	if mode == AnyMinerMayPass && len(errored) > 0 || len(failed) == 0 {
+		first = errored
+	}
+
+	var msgs []string
+	for _, run := range append(failed, errored...) {
+		msgs = append(msgs, fmt.Sprintf("%s: %v", run.MinerID, run.Err))
+	}
+	return &Error{
+		Kind: KindOf(first[0].Err),
+		Err:  fmt.Errorf("%s", strings.Join(msgs, "; ")),
+	}
+}
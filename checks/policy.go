@@ -0,0 +1,264 @@
+package checks
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultPolicyJSON is the policy used when neither KYC_POLICY nor
+// KYC_POLICY_PATH is set.
+//
+//go:embed default-policy.json
+var DefaultPolicyJSON []byte
+
+// Policy holds every tunable of the KYC checks. Its Version is stamped into
+// each response so a verdict can be traced back to the rules applied.
+type Policy struct {
+	Version string `json:"version"`
+	// MinPower is the minimum quality adjusted power, in bytes.
+	MinPower string      `json:"min_power"`
+	Miners   MinerPolicy `json:"miners"`
+	Geo      GeoPolicy   `json:"geo"`
+	Feeds    FeedPolicy  `json:"feeds"`
+
+	minPower *big.Int
+}
+
+// MinerPolicy governs submissions listing several miners.
+type MinerPolicy struct {
+	// Pass is "all" (every miner must pass) or "any" (one is enough).
+	Pass MinerPass `json:"pass"`
+	// MaxPerSubmission caps how many miners one submission may list.
+	MaxPerSubmission int `json:"max_per_submission"`
+}
+
+type GeoPolicy struct {
+	// MaxDistanceKm is how far an IP may be located from the city the
+	// miner claims.
+	MaxDistanceKm float64 `json:"max_distance_km"`
+	// IPMaxAgeEpochs is how long ago an IP must have been seen to count.
+	IPMaxAgeEpochs int64 `json:"ip_max_age_epochs"`
+	// MinCitySimilarity is how alike, from 0 to 1, a provider's city name
+	// must be to the miner's to match when they differ once normalized. 1
+	// only allows exact and normalized matches.
+	MinCitySimilarity float64 `json:"min_city_similarity"`
+	// CountryOverrides replaces the settings above per ISO country code.
+	CountryOverrides map[string]CountryPolicy `json:"country_overrides,omitempty"`
+	// Providers are the sources of IP geolocation data, tried in order
+	// until one places an IP address of the miner near its city.
+	Providers []GeoProviderPolicy `json:"providers"`
+}
+
+// GeoProviderPolicy enables one source of IP geolocation data.
+type GeoProviderPolicy struct {
+	Name string `json:"name"`
+	// Countries restricts the provider to miners in these ISO country
+	// codes. It applies everywhere when empty.
+	Countries []string `json:"countries,omitempty"`
+}
+
+// DefaultMinCitySimilarity is the MinCitySimilarity of policies that don't
+// set one, which allows a typo or a variant spelling in a name of ten or so
+// letters.
+const DefaultMinCitySimilarity = 0.85
+
+// DefaultGeoProviders is the provider chain of policies that don't list one:
+// Baidu for China, then the GeoLite2 feed, a local MaxMind database, GeoIP2
+// and ipinfo.
+var DefaultGeoProviders = []GeoProviderPolicy{
+	{Name: "baidu", Countries: []string{"CN"}},
+	{Name: "geolite2"},
+	{Name: "mmdb"},
+	{Name: "geoip2"},
+	{Name: "ipinfo"},
+}
+
+// AppliesTo reports whether the provider should be asked about a miner in
+// country.
+func (p GeoProviderPolicy) AppliesTo(country string) bool {
+	if len(p.Countries) == 0 {
+		return true
+	}
+	for _, c := range p.Countries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+type CountryPolicy struct {
+	MaxDistanceKm float64 `json:"max_distance_km,omitempty"`
+}
+
+// FeedPolicy holds the URLs of the provider.quest data feeds.
+type FeedPolicy struct {
+	MultiaddrsIPs string `json:"multiaddrs_ips"`
+	IPsGeolite2   string `json:"ips_geolite2"`
+	IPsBaidu      string `json:"ips_baidu"`
+	// MaxAge is how long a downloaded feed is used before checking for a
+	// newer one, as a duration such as "30m". Feeds are checked on every
+	// request when it's empty.
+	MaxAge string `json:"max_age,omitempty"`
+	// MaxStaleness is how long after provider.quest generated a feed it's
+	// still current, as a duration such as "48h". Feed dates aren't checked
+	// when it's empty.
+	MaxStaleness string `json:"max_staleness,omitempty"`
+	// OnStale is what happens to a feed older than MaxStaleness.
+	OnStale StaleAction `json:"on_stale,omitempty"`
+
+	maxAge       time.Duration
+	maxStaleness time.Duration
+}
+
+// StaleAction is what the geoip check does with a stale feed.
+type StaleAction string
+
+const (
+	// RejectStaleFeeds fails the check as upstream unavailable, rather than
+	// blame the applicant for data that's out of date.
+	RejectStaleFeeds StaleAction = "reject"
+	// FlagStaleFeeds uses the feed anyway, and names it in the evidence.
+	FlagStaleFeeds StaleAction = "flag"
+)
+
+// LoadPolicy reads the policy from the KYC_POLICY environment variable
+// (the JSON document itself), the file named by KYC_POLICY_PATH, or the
+// embedded default, in that order.
+func LoadPolicy() (*Policy, error) {
+	if doc := os.Getenv("KYC_POLICY"); doc != "" {
+		return ParsePolicy([]byte(doc))
+	}
+	if path := os.Getenv("KYC_POLICY_PATH"); path != "" {
+		doc, err := os.ReadFile(path)
+		if err != nil {
+			return nil, Errorf(KindInternal, "reading policy: %w", err)
+		}
+		return ParsePolicy(doc)
+	}
+	return ParsePolicy(DefaultPolicyJSON)
+}
+
+// ParsePolicy parses and validates a policy document.
+func ParsePolicy(doc []byte) (*Policy, error) {
+	var p Policy
+	if err := json.Unmarshal(doc, &p); err != nil {
+		return nil, Errorf(KindInternal, "parsing policy: %w", err)
+	}
+	if err := p.validate(); err != nil {
+		return nil, Errorf(KindInternal, "invalid policy %q: %w", p.Version, err)
+	}
+	return &p, nil
+}
+
+func (p *Policy) validate() error {
+	if p.Version == "" {
+		return fmt.Errorf("missing version")
+	}
+
+	var ok bool
+	p.minPower, ok = new(big.Int).SetString(p.MinPower, 10)
+	if !ok || p.minPower.Sign() < 0 {
+		return fmt.Errorf("min_power %q is not a byte count", p.MinPower)
+	}
+
+	switch p.Miners.Pass {
+	case "":
+		p.Miners.Pass = AllMinersMustPass
+	case AllMinersMustPass, AnyMinerMayPass:
+	default:
+		return fmt.Errorf("miners.pass must be %q or %q", AllMinersMustPass, AnyMinerMayPass)
+	}
+	if p.Miners.MaxPerSubmission < 0 {
+		return fmt.Errorf("miners.max_per_submission is negative")
+	}
+
+	if p.Geo.MaxDistanceKm <= 0 {
+		return fmt.Errorf("geo.max_distance_km must be positive")
+	}
+	if p.Geo.IPMaxAgeEpochs <= 0 {
+		return fmt.Errorf("geo.ip_max_age_epochs must be positive")
+	}
+	if p.Geo.MinCitySimilarity == 0 {
+		p.Geo.MinCitySimilarity = DefaultMinCitySimilarity
+	}
+	if p.Geo.MinCitySimilarity < 0 || p.Geo.MinCitySimilarity > 1 {
+		return fmt.Errorf("geo.min_city_similarity must be between 0 and 1")
+	}
+	overrides := make(map[string]CountryPolicy, len(p.Geo.CountryOverrides))
+	for country, o := range p.Geo.CountryOverrides {
+		if o.MaxDistanceKm < 0 {
+			return fmt.Errorf("geo.country_overrides.%s.max_distance_km is negative", country)
+		}
+		overrides[strings.ToUpper(country)] = o
+	}
+	p.Geo.CountryOverrides = overrides
+	if len(p.Geo.Providers) == 0 {
+		// A copy, so that editing one policy doesn't edit the defaults
+		p.Geo.Providers = make([]GeoProviderPolicy, len(DefaultGeoProviders))
+		for i, provider := range DefaultGeoProviders {
+			provider.Countries = append([]string(nil), provider.Countries...)
+			p.Geo.Providers[i] = provider
+		}
+	}
+	for i, provider := range p.Geo.Providers {
+		if provider.Name == "" {
+			return fmt.Errorf("geo.providers[%d] has no name", i)
+		}
+	}
+
+	if p.Feeds.MultiaddrsIPs == "" || p.Feeds.IPsGeolite2 == "" || p.Feeds.IPsBaidu == "" {
+		return fmt.Errorf("feeds must set multiaddrs_ips, ips_geolite2 and ips_baidu")
+	}
+	if p.Feeds.MaxAge != "" {
+		maxAge, err := time.ParseDuration(p.Feeds.MaxAge)
+		if err != nil || maxAge < 0 {
+			return fmt.Errorf("feeds.max_age %q is not a duration", p.Feeds.MaxAge)
+		}
+		p.Feeds.maxAge = maxAge
+	}
+	if p.Feeds.MaxStaleness != "" {
+		maxStaleness, err := time.ParseDuration(p.Feeds.MaxStaleness)
+		if err != nil || maxStaleness <= 0 {
+			return fmt.Errorf("feeds.max_staleness %q is not a positive duration", p.Feeds.MaxStaleness)
+		}
+		p.Feeds.maxStaleness = maxStaleness
+	}
+	switch p.Feeds.OnStale {
+	case "":
+		p.Feeds.OnStale = FlagStaleFeeds
+	case RejectStaleFeeds, FlagStaleFeeds:
+	default:
+		return fmt.Errorf("feeds.on_stale must be %q or %q", RejectStaleFeeds, FlagStaleFeeds)
+	}
+	return nil
+}
+
+// MinPowerBytes returns MinPower as a number.
+func (p *Policy) MinPowerBytes() *big.Int {
+	return new(big.Int).Set(p.minPower)
+}
+
+// MaxAgeDuration returns MaxAge as a duration.
+func (f FeedPolicy) MaxAgeDuration() time.Duration {
+	return f.maxAge
+}
+
+// MaxStalenessDuration returns MaxStaleness as a duration, zero when feed
+// dates aren't checked.
+func (f FeedPolicy) MaxStalenessDuration() time.Duration {
+	return f.maxStaleness
+}
+
+// MaxDistanceKmFor returns the match radius for an ISO country code.
+func (g GeoPolicy) MaxDistanceKmFor(country string) float64 {
+	if o, ok := g.CountryOverrides[strings.ToUpper(country)]; ok && o.MaxDistanceKm > 0 {
+		return o.MaxDistanceKm
+	}
+	return g.MaxDistanceKm
+}
@@ -0,0 +1,89 @@
+package checks
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	t.Setenv("KYC_POLICY", "")
+	t.Setenv("KYC_POLICY_PATH", "")
+
+	p, err := LoadPolicy()
+	assert.Nil(t, err)
+	assert.NotEmpty(t, p.Version)
+	assert.Equal(t, "10995116277760", p.MinPowerBytes().String())
+	assert.Equal(t, 600.0, p.Geo.MaxDistanceKmFor("PL"))
+	assert.Equal(t, 1500.0, p.Geo.MaxDistanceKmFor("ru"))
+	assert.EqualValues(t, 14*24*60*2, p.Geo.IPMaxAgeEpochs)
+	assert.Equal(t, 0.85, p.Geo.MinCitySimilarity)
+	assert.Equal(t, DefaultGeoProviders, p.Geo.Providers)
+	assert.Equal(t, 30*time.Minute, p.Feeds.MaxAgeDuration())
+	assert.Equal(t, 72*time.Hour, p.Feeds.MaxStalenessDuration())
+	assert.Equal(t, RejectStaleFeeds, p.Feeds.OnStale)
+	assert.True(t, p.Geo.Providers[0].AppliesTo("cn"))
+	assert.False(t, p.Geo.Providers[0].AppliesTo("PL"))
+	assert.True(t, p.Geo.Providers[1].AppliesTo("PL"))
+}
+
+func TestLoadPolicy(t *testing.T) {
+	doc := `{
+		"version": "test-1",
+		"min_power": "1",
+		"geo": {
+			"max_distance_km": 100,
+			"ip_max_age_epochs": 10,
+			"country_overrides": {"ca": {"max_distance_km": 300}}
+		},
+		"feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c"}
+	}`
+
+	t.Setenv("KYC_POLICY_PATH", "")
+	t.Setenv("KYC_POLICY", doc)
+	p, err := LoadPolicy()
+	assert.Nil(t, err)
+	assert.Equal(t, "test-1", p.Version)
+	assert.Equal(t, 300.0, p.Geo.MaxDistanceKmFor("CA"))
+	assert.Equal(t, 100.0, p.Geo.MaxDistanceKmFor("US"))
+	assert.Equal(t, DefaultMinCitySimilarity, p.Geo.MinCitySimilarity)
+	assert.Equal(t, DefaultGeoProviders, p.Geo.Providers)
+	assert.Zero(t, p.Feeds.MaxAgeDuration())
+	assert.Zero(t, p.Feeds.MaxStalenessDuration())
+	assert.Equal(t, FlagStaleFeeds, p.Feeds.OnStale)
+
+	path := filepath.Join(t.TempDir(), "policy.json")
+	assert.Nil(t, os.WriteFile(path, []byte(doc), 0644))
+	t.Setenv("KYC_POLICY", "")
+	t.Setenv("KYC_POLICY_PATH", path)
+	p, err = LoadPolicy()
+	assert.Nil(t, err)
+	assert.Equal(t, "test-1", p.Version)
+
+	// Each policy has its own copy of the default providers
+	p.Geo.Providers[0].Name = "edited"
+	p.Geo.Providers[0].Countries[0] = "PL"
+	assert.Equal(t, "baidu", DefaultGeoProviders[0].Name)
+	assert.Equal(t, []string{"CN"}, DefaultGeoProviders[0].Countries)
+}
+
+func TestInvalidPolicy(t *testing.T) {
+	for _, doc := range []string{
+		`{`,
+		`{"min_power": "1"}`,
+		`{"version": "v", "min_power": "lots"}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 0, "ip_max_age_epochs": 1}}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 1, "ip_max_age_epochs": 1}}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 1, "ip_max_age_epochs": 1, "min_city_similarity": 1.5}, "feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c"}}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 1, "ip_max_age_epochs": 1, "providers": [{"countries": ["CN"]}]}, "feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c"}}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 1, "ip_max_age_epochs": 1}, "feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c", "max_age": "daily"}}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 1, "ip_max_age_epochs": 1}, "feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c", "max_staleness": "-1h"}}`,
+		`{"version": "v", "min_power": "1", "geo": {"max_distance_km": 1, "ip_max_age_epochs": 1}, "feeds": {"multiaddrs_ips": "a", "ips_geolite2": "b", "ips_baidu": "c", "on_stale": "ignore"}}`,
+	} {
+		_, err := ParsePolicy([]byte(doc))
+		assert.Equal(t, KindInternal, KindOf(err), doc)
+	}
+}
@@ -3,49 +3,73 @@ package main
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"log"
-	"math/big"
-	"strconv"
+	"net/http"
+	"os"
+	"sync"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
-	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/geoip"
-	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/minpower"
+	_ "github.com/data-preservation-programs/ground-control-kyc-lambda/checks/all"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks/chain"
+	"github.com/filecoin-project/go-address"
 )
 
 // checks.NormalizedResponse, passFail, error
+//
+// Errors never escape as a Lambda invocation failure: every error is mapped
+// onto an HTTP status by its checks.Kind and described in the JSON body.
 func handleRequest(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	policy, err := kycPolicy()
+	if err != nil {
+		return errorResponse(err, ""), nil
+	}
+	fail := func(err error) events.APIGatewayProxyResponse {
+		return errorResponse(err, policy.Version)
+	}
+
 	var formSubmission checks.FormSubmission
-	err := json.Unmarshal([]byte(request.Body), &formSubmission)
+	err = json.Unmarshal([]byte(request.Body), &formSubmission)
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: 400}, fmt.Errorf("failed to deserialize request body: %v", err)
+		return fail(checks.Errorf(checks.KindInvalidInput, "failed to deserialize request body: %w", err)), nil
+	}
+
+	if err := formSubmission.Validate(policy.Miners.MaxPerSubmission); err != nil {
+		return fail(err), nil
 	}
 
 	ctx := context.Background()
 
-	// check miner power before geoip
-	pass, err := checkMinerPower(ctx, formSubmission.MinerID)
+	clock, err := epochClock()
 	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: 400}, err
+		return fail(err), nil
 	}
 
-	if !pass {
-		return events.APIGatewayProxyResponse{StatusCode: 400}, errors.New("miner power too low")
+	// Checks that need the chain report the dial error themselves; the
+	// others (geoip runs off the clock) carry on without it.
+	api, err := lotusClient(ctx, clock)
+	if err != nil {
+		log.Printf("Lotus unavailable: %v\n", err)
+		api = chain.Unavailable(err)
 	}
 
-	checker := geoip.GeoIPCheck{}
+	pipeline, err := checks.Pipeline(checks.ParseOrder(os.Getenv("CHECK_ORDER")))
+	if err != nil {
+		return fail(err), nil
+	}
 
-	location, err := checker.DoCheck(ctx, geoip.MinerData{
-		MinerID:     formSubmission.MinerID,
-		City:        formSubmission.City,
-		CountryCode: formSubmission.Country,
+	// A failed check still returns the report, so the applicant can see
+	// which check rejected them and why.
+	runs := checks.RunMiners(ctx, pipeline, formSubmission, checks.State{
+		Lotus:  api,
+		Clock:  clock,
+		Policy: policy,
 	})
-	if err != nil {
-		log.Fatalln(err)
-		return events.APIGatewayProxyResponse{StatusCode: 400}, err
+	runErr := policy.Miners.Pass.Verdict(runs)
+	if runErr != nil {
+		log.Printf("KYC checks failed: %v\n", runErr)
 	}
 
 	contactInfoMap := map[string]string{
@@ -56,68 +80,145 @@ func handleRequest(request events.APIGatewayProxyRequest) (events.APIGatewayProx
 
 	contactInfoJSON, err := json.Marshal(contactInfoMap)
 	if err != nil {
-		log.Fatalln(err)
-		contactInfoJSON = []byte("{}") // TODO should fail here or continue?
-	}
-
-	// remove the f0 prefix from miner id to store as int in postgres
-	minerIDInt, err := strconv.Atoi(formSubmission.MinerID[2:])
-	if err != nil {
-		log.Fatalln(err)
+		return fail(checks.Errorf(checks.KindInternal, "failed to serialize contact info: %w", err)), nil
 	}
 
 	result := checks.NormalizedResponse{
 		FormSubmission: formSubmission,
-		NormalizedMiner: checks.NormalizedMiner{
-			SPID:          minerIDInt,
-			LocCity:       location.LocCity,
-			LocCountry:    location.LocCountry,
-			LocContinent:  location.LocContinent,
-			Validated:     true,
-			SPContactInfo: string(contactInfoJSON), // TODO: we need to seperate sp contact info
-		},
 		NormalizedOrg: checks.NormalizedOrg{
 			SPOrgID:        "", // TODO: need to get appropriate OrgID or create one
 			SPOrganization: formSubmission.SPName,
 			OrgContactInfo: string(contactInfoJSON), // TODO: we need to seperate sp contact info
 		},
+		PolicyVersion: policy.Version,
 	}
 
-	jsonResponse, err := json.Marshal(result)
-	if err != nil {
-		return events.APIGatewayProxyResponse{StatusCode: 4}, fmt.Errorf("failed to serialize response: %v", err)
+	for _, run := range runs {
+		// remove the f0 prefix from miner id to store as int in postgres
+		minerAddr, err := checks.ParseMinerID(run.MinerID)
+		if err != nil {
+			return fail(err), nil
+		}
+		minerIDInt, err := address.IDFromAddress(minerAddr)
+		if err != nil {
+			return fail(checks.Errorf(checks.KindInvalidInput, "invalid miner ID: %w", err)), nil
+		}
+
+		miner := run.State.Miner
+		miner.Merge(checks.NormalizedMiner{
+			SPID:          int(minerIDInt),
+			Validated:     run.Err == nil,
+			SPContactInfo: string(contactInfoJSON), // TODO: we need to seperate sp contact info
+		})
+		result.NormalizedMiners = append(result.NormalizedMiners, miner)
+		result.Checks = append(result.Checks, run.State.Checks...)
 	}
 
-	apiResponse := events.APIGatewayProxyResponse{
-		StatusCode: 200,
-		Body:       string(jsonResponse),
-		Headers: map[string]string{
-			"Content-Type": "application/json",
-		},
+	statusCode := http.StatusOK
+	if runErr != nil {
+		result.Error = checks.NewErrorBody(runErr)
+		statusCode = result.Error.Kind.HTTPStatus()
 	}
 
-	return apiResponse, nil
+	return jsonResponse(statusCode, result), nil
+}
+
+// lotus is dialed on first use and then shared by every invocation this
+// container handles.
+var lotus struct {
+	sync.Mutex
+	client *chain.Client
 }
 
-func checkMinerPower(ctx context.Context, minerID string) (bool, error) {
-	// 10TiB = 10 * 1024^4 = 10995116277760 min power requirement
-	min, ok := new(big.Int).SetString("10995116277760", 10)
+func lotusClient(ctx context.Context, clock *chain.Clock) (chain.API, error) {
+	lotus.Lock()
+	defer lotus.Unlock()
 
-	if !ok {
-		return false, errors.New("failed to parse big int")
+	if lotus.client != nil {
+		return lotus.client, nil
 	}
 
-	pass, err := minpower.MinQualityPowerOk(ctx, minerID, min)
+	cfg, err := chain.ConfigFromEnv()
 	if err != nil {
-		log.Fatalln(err)
-		return false, err
+		return nil, checks.Errorf(checks.KindInternal, "lotus config: %w", err)
 	}
 
-	if !pass {
-		return false, errors.New("miner power too low")
+	client, err := chain.Dial(ctx, cfg)
+	if err != nil {
+		return nil, checks.Errorf(checks.KindUpstreamUnavailable, "%w", err)
 	}
 
-	return true, nil
+	lotus.client = client
+
+	// Only logs: a node out of sync shouldn't stop the checks.
+	if _, _, err := clock.CrossCheck(ctx, client); err != nil {
+		log.Printf("Unable to cross-check epoch clock: %v\n", err)
+	}
+
+	return client, nil
+}
+
+// sharedClock derives epochs for the network named by FILECOIN_NETWORK.
+var sharedClock struct {
+	sync.Once
+	clock *chain.Clock
+	err   error
+}
+
+func epochClock() (*chain.Clock, error) {
+	sharedClock.Do(func() {
+		network, err := chain.NetworkFromEnv()
+		if err != nil {
+			sharedClock.err = checks.Errorf(checks.KindInternal, "%w", err)
+			return
+		}
+		sharedClock.clock = chain.NewClock(network)
+	})
+	return sharedClock.clock, sharedClock.err
+}
+
+// sharedPolicy is loaded once per container, see checks.LoadPolicy.
+var sharedPolicy struct {
+	sync.Once
+	policy *checks.Policy
+	err    error
+}
+
+func kycPolicy() (*checks.Policy, error) {
+	sharedPolicy.Do(func() {
+		sharedPolicy.policy, sharedPolicy.err = checks.LoadPolicy()
+		if sharedPolicy.err == nil {
+			log.Printf("Loaded KYC policy %s\n", sharedPolicy.policy.Version)
+		}
+	})
+	return sharedPolicy.policy, sharedPolicy.err
+}
+
+// errorResponse is the response for requests that fail before the checks
+// produce a report.
+func errorResponse(err error, policyVersion string) events.APIGatewayProxyResponse {
+	log.Printf("Request failed: %v\n", err)
+	body := checks.NewErrorBody(err)
+	return jsonResponse(body.Kind.HTTPStatus(), struct {
+		Error         *checks.ErrorBody
+		PolicyVersion string `json:",omitempty"`
+	}{body, policyVersion})
+}
+
+func jsonResponse(statusCode int, body interface{}) events.APIGatewayProxyResponse {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		payload = []byte(fmt.Sprintf(`{"Error":{"kind":%q,"message":"failed to serialize response"}}`, checks.KindInternal))
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: statusCode,
+		Body:       string(payload),
+		Headers: map[string]string{
+			"Content-Type": "application/json",
+		},
+	}
 }
 
 func main() {
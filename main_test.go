@@ -1,8 +1,13 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
 	"testing"
 
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/data-preservation-programs/ground-control-kyc-lambda/checks"
+	"github.com/filecoin-project/go-address"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -52,3 +57,47 @@ func LambdaTest(t *testing.T) {
 		assert.Equal(t, c.SlackID, "user_123")
 	}
 }
+
+// A log.Fatal or panic anywhere on these paths would kill the test binary,
+// just like it would kill the Lambda runtime.
+func TestHandleRequestBadInput(t *testing.T) {
+	actor, err := address.NewActorAddress([]byte("not a miner"))
+	assert.Nil(t, err)
+
+	bodies := []string{
+		``,
+		`{`,
+		`null`,
+		`[]`,
+		`{"minerid": 1000}`,
+		`{"minerid": ""}`,
+		`{"minerid": "f"}`,
+		`{"minerid": "f0"}`,
+		`{"minerid": "f0abc"}`,
+		`{"minerid": "x01000"}`,
+		`{"minerid": "f099999999999999999999999999"}`,
+		fmt.Sprintf(`{"minerid": %q}`, actor.String()),
+		`{"sp_ids": []}`,
+		`{"sp_ids": "f01000"}`,
+		`{"sp_ids": ["f01000", "f0abc"]}`,
+		`{"minerid": "f01000", "sp_ids": ["f01", "f02", "f03", "f04", "f05", "f06", "f07", "f08", "f09", "f010", "f011", "f012", "f013", "f014", "f015", "f016", "f017", "f018", "f019", "f020"]}`,
+	}
+
+	for _, body := range bodies {
+		resp, err := handleRequest(events.APIGatewayProxyRequest{Body: body})
+		assert.Nil(t, err, body)
+		assert.Equal(t, 400, resp.StatusCode, body)
+		assert.Equal(t, "application/json", resp.Headers["Content-Type"])
+
+		var payload struct {
+			Error         *checks.ErrorBody
+			PolicyVersion string
+		}
+		assert.Nil(t, json.Unmarshal([]byte(resp.Body), &payload), body)
+		assert.NotEmpty(t, payload.PolicyVersion, body)
+		if assert.NotNil(t, payload.Error, body) {
+			assert.Equal(t, checks.KindInvalidInput, payload.Error.Kind, body)
+			assert.NotEmpty(t, payload.Error.Message, body)
+		}
+	}
+}